@@ -4,34 +4,85 @@ import (
 	"regexp"
 )
 
-// RemoveArg Remove 'targetArg' from 'args'.
+// RemoveArg Remove every occurrence of 'targetArg' from 'args'.
 // If 'removeArgVal' is true, remove the argument value as well.
 //
 // Example:
 //
 // args := []string{"-c", "hello.c", "-o", "hello"}
-// args = RemoveArg(args, "-c", true)
-// // args is now []string{"-o", "hello"}
-// args = RemoveArg(args, "-o", false)
-// // args is now []string{"hello"}
+// args = RemoveArg(args, "-o", true)
+// // args is now []string{"-c", "hello.c"}
+// args = RemoveArg(args, "-c", false)
+// // args is now []string{"hello.c"}
+//
+// If 'targetArg' is in the option table (see LookupOption()), every spelling
+// of it is removed (e.g., "-o foo", "-ofoo" and "--output=foo" for "-o").
+// Joined spellings are always removed with their value since they're a
+// single argument.
 //
 // Returns modified list of arguments
 func RemoveArg(args []string, targetArg string, removeArgVal bool) []string {
 	if len(targetArg) == 0 {
 		return args
 	}
-	for i, elem := range args {
-		if elem == targetArg {
-			if removeArgVal {
-				args = append(args[:i], args[i+1:]...)
-				args = append(args[:i], args[i+1:]...)
-			} else {
-				args = append(args[:i], args[i+1:]...)
-			}
-			return args
+	opt := LookupOption(targetArg)
+	if opt == nil {
+		return removeLiteralArg(args, targetArg, removeArgVal)
+	}
+	occurrences := findOccurrences(args, opt)
+	if len(occurrences) == 0 {
+		return args
+	}
+	isRemoved := make([]bool, len(args))
+	for _, occurrence := range occurrences {
+		isRemoved[occurrence.Index] = true
+		if occurrence.Count == 2 && removeArgVal {
+			isRemoved[occurrence.Index+1] = true
 		}
 	}
-	return args
+	newArgs := []string{}
+	for i, arg := range args {
+		if !isRemoved[i] {
+			newArgs = append(newArgs, arg)
+		}
+	}
+	return newArgs
+}
+
+// removeLiteralArg removes every argument in 'args' that is exactly
+// 'targetArg', and the argument after it if 'removeArgVal' is true
+func removeLiteralArg(args []string, targetArg string, removeArgVal bool) []string {
+	newArgs := []string{}
+	for i := 0; i < len(args); i++ {
+		if args[i] != targetArg {
+			newArgs = append(newArgs, args[i])
+			continue
+		}
+		if removeArgVal {
+			i++
+		}
+	}
+	return newArgs
+}
+
+// RemoveArgsAt removes the arguments at 'indices' from 'args', e.g., the
+// inputs that Parse() found, whatever their spelling. Unlike RemoveArg(),
+// option values that happen to be spelled like them are kept.
+// Returns modified list of arguments
+func RemoveArgsAt(args []string, indices []int) []string {
+	isRemoved := make([]bool, len(args))
+	for _, idx := range indices {
+		if idx >= 0 && idx < len(args) {
+			isRemoved[idx] = true
+		}
+	}
+	newArgs := []string{}
+	for i, arg := range args {
+		if !isRemoved[i] {
+			newArgs = append(newArgs, arg)
+		}
+	}
+	return newArgs
 }
 
 // RemoveRegexArg removes arguments from 'args' that match 'regex'.
@@ -64,11 +115,19 @@ func AddArg(args []string, newArg string, newArgVal string) []string {
 	return args
 }
 
-// HasArg returns true if 'args' contains 'targetArgName'
+// HasArg returns true if 'args' contains 'targetArgName'.
+//
+// If 'targetArgName' is in the option table (see LookupOption()), any
+// spelling of it counts, but not an argument that is the value of another
+// option (e.g., "-Xclang -g" has no "-g")
 func HasArg(args []string, targetArgName string) bool {
 	if len(args) == 0 || len(targetArgName) == 0 {
 		return false
 	}
+	opt := LookupOption(targetArgName)
+	if opt != nil {
+		return len(findOccurrences(args, opt)) != 0
+	}
 	for _, elem := range args {
 		if elem == targetArgName {
 			return true
@@ -78,10 +137,22 @@ func HasArg(args []string, targetArgName string) bool {
 }
 
 // GetArgVal returns the value of 'targetArgName' in 'args'.
+//
+// If 'targetArgName' is in the option table (see LookupOption()), any
+// spelling of it counts and, like in clang, the last occurrence wins.
+// Otherwise, it's the argument after the first occurrence
 func GetArgVal(args []string, targetArgName string) string {
 	if len(args) == 0 || len(targetArgName) == 0 {
 		return ""
 	}
+	opt := LookupOption(targetArgName)
+	if opt != nil {
+		vals := GetArgVals(args, targetArgName)
+		if len(vals) == 0 {
+			return ""
+		}
+		return vals[len(vals)-1]
+	}
 	for i, elem := range args {
 		if elem == targetArgName && i+1 < len(args) {
 			return args[i+1]
@@ -89,3 +160,26 @@ func GetArgVal(args []string, targetArgName string) string {
 	}
 	return ""
 }
+
+// GetArgVals returns the values of every occurrence of 'targetArgName' in
+// 'args', in order. Comma-joined options (e.g., "-Wl,") have one value per
+// comma-separated item
+func GetArgVals(args []string, targetArgName string) []string {
+	if len(args) == 0 || len(targetArgName) == 0 {
+		return nil
+	}
+	vals := []string{}
+	opt := LookupOption(targetArgName)
+	if opt != nil {
+		for _, occurrence := range findOccurrences(args, opt) {
+			vals = append(vals, occurrence.Values...)
+		}
+		return vals
+	}
+	for i, elem := range args {
+		if elem == targetArgName && i+1 < len(args) {
+			vals = append(vals, args[i+1])
+		}
+	}
+	return vals
+}
@@ -92,6 +92,63 @@ func TestRemoveArg(t *testing.T) {
 	}
 }
 
+func TestRemoveArgEveryOccurrence(t *testing.T) {
+	var testcases = []struct {
+		name                string
+		inputArgs           []string
+		targetArg           string
+		inputDoRemoveArgVal bool
+		expectedArgs        []string
+	}{
+		{
+			name: "Every spelling of an option with a value",
+			inputArgs: []string{
+				"-o", "a.o", "-c", "hello.c", "-ob.o", "--output=c.o",
+			},
+			targetArg:           "-o",
+			inputDoRemoveArgVal: true,
+			expectedArgs:        []string{"-c", "hello.c"},
+		},
+		{
+			name:                "Keep the value of a separate spelling",
+			inputArgs:           []string{"-MF", "dep.d", "-MFdep2.d"},
+			targetArg:           "-MF",
+			inputDoRemoveArgVal: false,
+			expectedArgs:        []string{"dep.d"},
+		},
+		{
+			name:                "Every occurrence of a flag",
+			inputArgs:           []string{"-fembed-bitcode", "-c", "-fembed-bitcode"},
+			targetArg:           "-fembed-bitcode",
+			inputDoRemoveArgVal: false,
+			expectedArgs:        []string{"-c"},
+		},
+		{
+			name:                "Every occurrence of an unknown argument",
+			inputArgs:           []string{"-aaa", "1", "-bbb", "-aaa", "2"},
+			targetArg:           "-aaa",
+			inputDoRemoveArgVal: true,
+			expectedArgs:        []string{"-bbb"},
+		},
+		{
+			name:                "Don't remove the value of another option",
+			inputArgs:           []string{"-Xclang", "-g", "-g", "-c"},
+			targetArg:           "-g",
+			inputDoRemoveArgVal: false,
+			expectedArgs:        []string{"-Xclang", "-g", "-c"},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(
+				t,
+				tc.expectedArgs,
+				RemoveArg(tc.inputArgs, tc.targetArg, tc.inputDoRemoveArgVal),
+			)
+		})
+	}
+}
+
 func TestRemoveRegexArg(t *testing.T) {
 	var testcases = []struct {
 		name                 string
@@ -245,6 +302,24 @@ func TestHasArg(t *testing.T) {
 			targetArg:      "",
 			expectedRetval: false,
 		},
+		{
+			name:           "Joined spelling",
+			inputArgs:      []string{"-c", "hello.c", "-ohello.o"},
+			targetArg:      "-o",
+			expectedRetval: true,
+		},
+		{
+			name:           "Alias spelling",
+			inputArgs:      []string{"-c", "hello.c", "--output=hello.o"},
+			targetArg:      "-o",
+			expectedRetval: true,
+		},
+		{
+			name:           "Value of another option",
+			inputArgs:      []string{"-c", "hello.c", "-Xclang", "-g"},
+			targetArg:      "-g",
+			expectedRetval: false,
+		},
 	}
 	for _, tc := range testcases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -288,6 +363,48 @@ func TestGetArgVal(t *testing.T) {
 			inputTargetArg: "",
 			expectedRetval: "",
 		},
+		{
+			name:           "Joined spelling",
+			inputArgs:      []string{"-c", "hello.c", "-MFdep.d"},
+			inputTargetArg: "-MF",
+			expectedRetval: "dep.d",
+		},
+		{
+			name:           "Joined language",
+			inputArgs:      []string{"-xc++", "-c", "hello.c"},
+			inputTargetArg: "-x",
+			expectedRetval: "c++",
+		},
+		{
+			name:           "Long alias spelling",
+			inputArgs:      []string{"-c", "hello.c", "--output=hello.o"},
+			inputTargetArg: "-o",
+			expectedRetval: "hello.o",
+		},
+		{
+			name:           "Separate long alias spelling",
+			inputArgs:      []string{"-c", "hello.c", "--output", "hello.o"},
+			inputTargetArg: "-o",
+			expectedRetval: "hello.o",
+		},
+		{
+			name:           "Last occurrence wins",
+			inputArgs:      []string{"-o", "a.o", "-c", "hello.c", "-ob.o"},
+			inputTargetArg: "-o",
+			expectedRetval: "b.o",
+		},
+		{
+			name:           "Longest option wins",
+			inputArgs:      []string{"-include-pch", "foo.pch", "-c", "hello.c"},
+			inputTargetArg: "-include",
+			expectedRetval: "",
+		},
+		{
+			name:           "Flag has no value",
+			inputArgs:      []string{"-c", "hello.c"},
+			inputTargetArg: "-c",
+			expectedRetval: "",
+		},
 	}
 	for _, tc := range testcases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -299,3 +416,71 @@ func TestGetArgVal(t *testing.T) {
 		})
 	}
 }
+
+func TestGetArgVals(t *testing.T) {
+	var testcases = []struct {
+		name           string
+		inputArgs      []string
+		inputTargetArg string
+		expectedRetval []string
+	}{
+		{
+			name:           "Multiple occurrences",
+			inputArgs:      []string{"-arch", "arm64", "-c", "-arch", "x86_64"},
+			inputTargetArg: "-arch",
+			expectedRetval: []string{"arm64", "x86_64"},
+		},
+		{
+			name:           "Single occurrence",
+			inputArgs:      []string{"-arch", "arm64", "-c"},
+			inputTargetArg: "-arch",
+			expectedRetval: []string{"arm64"},
+		},
+		{
+			name:           "No occurrence",
+			inputArgs:      []string{"-c", "hello.c"},
+			inputTargetArg: "-arch",
+			expectedRetval: []string{},
+		},
+		{
+			name:           "Empty args",
+			inputArgs:      []string{},
+			inputTargetArg: "-arch",
+			expectedRetval: nil,
+		},
+		{
+			name: "Every spelling",
+			inputArgs: []string{
+				"-isystem/foo", "-isystem", "/bar", "-c", "hello.c",
+			},
+			inputTargetArg: "-isystem",
+			expectedRetval: []string{"/foo", "/bar"},
+		},
+		{
+			name:           "Comma-joined",
+			inputArgs:      []string{"-Wl,-foo,bar", "-Wl,baz"},
+			inputTargetArg: "-Wl,",
+			expectedRetval: []string{"-foo", "bar", "baz"},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(
+				t,
+				tc.expectedRetval,
+				GetArgVals(tc.inputArgs, tc.inputTargetArg),
+			)
+		})
+	}
+}
+
+func TestRemoveArgsAt(t *testing.T) {
+	args := []string{"-Xclang", "-main-file-name", "-Xclang", "a.c", "-c", "a.c"}
+	require.Equal(
+		t,
+		[]string{"-Xclang", "-main-file-name", "-Xclang", "a.c", "-c"},
+		RemoveArgsAt(args, []int{5}),
+	)
+	// Out of range indices are ignored
+	require.Equal(t, args, RemoveArgsAt(args, []int{-1, 6}))
+}
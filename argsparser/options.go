@@ -0,0 +1,376 @@
+package argsparser
+
+import (
+	"sort"
+	"strings"
+)
+
+// OptionKind is how an option takes its value. Kinds are modeled on clang's
+// Options.td
+type OptionKind int
+
+const (
+	// OptionKind_Flag takes no value (e.g., "-c")
+	OptionKind_Flag OptionKind = iota
+	// OptionKind_Joined takes the rest of the argument as its value (e.g.,
+	// "-O2")
+	OptionKind_Joined
+	// OptionKind_Separate takes the next argument as its value (e.g.,
+	// "-arch arm64")
+	OptionKind_Separate
+	// OptionKind_JoinedOrSeparate takes the rest of the argument as its
+	// value, or the next argument if there's no rest (e.g., "-ofoo" and
+	// "-o foo")
+	OptionKind_JoinedOrSeparate
+	// OptionKind_CommaJoined takes the rest of the argument as a list of
+	// comma-separated values (e.g., "-Wl,-foo,bar")
+	OptionKind_CommaJoined
+)
+
+// Option is a compiler option Conjunct knows about
+type Option struct {
+	// Name is how the option is spelled, including its dashes and, for
+	// joined options, any trailing '=' or ','
+	Name string
+	Kind OptionKind
+	// AliasOf is the name of the option this option is another spelling of
+	// (e.g., "--output=" is an alias of "-o"). Empty if it's not an alias
+	AliasOf string
+}
+
+// canonicalName returns the name of the option 'o' is an alias of, or its
+// own name
+func (o *Option) canonicalName() string {
+	if len(o.AliasOf) != 0 {
+		return o.AliasOf
+	}
+	return o.Name
+}
+
+// options is the table of compiler options Conjunct knows about. It's a small
+// subset of clang's options: only the ones Conjunct looks up or edits, the
+// ones that take a value (so that their value is not mistaken for an input)
+// and a few flags that would otherwise be parsed as a joined option (e.g.,
+// "-object" is not "-o bject").
+//
+// XXX The value of a separate option that's missing here is parsed as an
+// input: add every separate option clang builds are known to pass. Since
+// joined options match by prefix, so is the value of a missing option that
+// starts like a joined one (e.g., "-isystem-after /x" would be "-isystem"
+// with an "-after" value): add every longer spelling in clang's Options.td
+// too
+//
+// Arguments that don't match any option here are treated literally
+var options = []Option{
+	// Compilation modes
+	{Name: "-c", Kind: OptionKind_Flag},
+	{Name: "-S", Kind: OptionKind_Flag},
+	{Name: "-E", Kind: OptionKind_Flag},
+	{Name: "-emit-llvm", Kind: OptionKind_Flag},
+	{Name: "-fsyntax-only", Kind: OptionKind_Flag},
+	{Name: "-###", Kind: OptionKind_Flag},
+	{Name: "--precompile", Kind: OptionKind_Flag},
+	{Name: "-fmodule-header", Kind: OptionKind_Flag},
+	{
+		Name:    "-fmodule-header=",
+		Kind:    OptionKind_Joined,
+		AliasOf: "-fmodule-header",
+	},
+	// Output and language
+	{Name: "-o", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "--output=", Kind: OptionKind_Joined, AliasOf: "-o"},
+	{Name: "--output", Kind: OptionKind_Separate, AliasOf: "-o"},
+	{Name: "-object", Kind: OptionKind_Flag},
+	{Name: "-object-file-name", Kind: OptionKind_Separate},
+	{
+		Name:    "-object-file-name=",
+		Kind:    OptionKind_Joined,
+		AliasOf: "-object-file-name",
+	},
+	{Name: "-objcmt-", Kind: OptionKind_Joined},
+	{Name: "-x", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "--language=", Kind: OptionKind_Joined, AliasOf: "-x"},
+	{Name: "--language", Kind: OptionKind_Separate, AliasOf: "-x"},
+	{Name: "-O", Kind: OptionKind_Joined},
+	// Not optimization levels: treat the source files as Objective-C(++)
+	{Name: "-ObjC", Kind: OptionKind_Flag},
+	{Name: "-ObjC++", Kind: OptionKind_Flag},
+	// Targets
+	{Name: "-arch", Kind: OptionKind_Separate},
+	{Name: "-target", Kind: OptionKind_Separate},
+	{Name: "--target=", Kind: OptionKind_Joined, AliasOf: "-target"},
+	{Name: "-isysroot", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "--sysroot", Kind: OptionKind_Separate},
+	{Name: "--sysroot=", Kind: OptionKind_Joined, AliasOf: "--sysroot"},
+	// Preprocessor
+	{Name: "-D", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "--define-macro=", Kind: OptionKind_Joined, AliasOf: "-D"},
+	{Name: "-U", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "--undefine-macro=", Kind: OptionKind_Joined, AliasOf: "-U"},
+	{Name: "-I", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "--include-directory=", Kind: OptionKind_Joined, AliasOf: "-I"},
+	{Name: "-include", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-include-pch", Kind: OptionKind_Separate},
+	{Name: "-imacros", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-isystem", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-isystem-after", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-iquote", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-idirafter", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-iframework", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-iframeworkwithsysroot", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-iprefix", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-iwithprefix", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-iwithprefixbefore", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-iwithsysroot", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-ivfsoverlay", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-F", Kind: OptionKind_JoinedOrSeparate},
+	// Toolchain and diagnostics
+	{Name: "-B", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-gcc-toolchain", Kind: OptionKind_Separate},
+	{Name: "-resource-dir", Kind: OptionKind_Separate},
+	{Name: "-resource-dir=", Kind: OptionKind_Joined, AliasOf: "-resource-dir"},
+	{Name: "-working-directory", Kind: OptionKind_JoinedOrSeparate},
+	{
+		Name:    "-working-directory=",
+		Kind:    OptionKind_Joined,
+		AliasOf: "-working-directory",
+	},
+	{Name: "-index-store-path", Kind: OptionKind_Separate},
+	{Name: "-dependency-file", Kind: OptionKind_Separate},
+	{Name: "--serialize-diagnostics", Kind: OptionKind_Separate},
+	{Name: "-serialize-diagnostics", Kind: OptionKind_Separate},
+	{Name: "-darwin-target-variant", Kind: OptionKind_Separate},
+	// Dependency files
+	{Name: "-M", Kind: OptionKind_Flag},
+	{Name: "-MM", Kind: OptionKind_Flag},
+	{Name: "-MD", Kind: OptionKind_Flag},
+	{Name: "-MMD", Kind: OptionKind_Flag},
+	{Name: "-MP", Kind: OptionKind_Flag},
+	{Name: "-MG", Kind: OptionKind_Flag},
+	{Name: "-MV", Kind: OptionKind_Flag},
+	{Name: "-MF", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-MT", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-MQ", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-MJ", Kind: OptionKind_JoinedOrSeparate},
+	// Debug info
+	{Name: "-g", Kind: OptionKind_Flag},
+	{Name: "-g0", Kind: OptionKind_Flag},
+	{Name: "-gmodules", Kind: OptionKind_Flag},
+	// Bitcode embedding
+	{Name: "-fembed-bitcode", Kind: OptionKind_Flag},
+	{Name: "-fembed-bitcode=", Kind: OptionKind_Joined},
+	{Name: "-fembed-bitcode-marker", Kind: OptionKind_Flag},
+	// Linker
+	{Name: "-L", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-framework", Kind: OptionKind_Separate},
+	{Name: "-weak_framework", Kind: OptionKind_Separate},
+	{Name: "-weak_library", Kind: OptionKind_Separate},
+	{Name: "-reexport_framework", Kind: OptionKind_Separate},
+	{Name: "-reexport_library", Kind: OptionKind_Separate},
+	{Name: "-install_name", Kind: OptionKind_Separate},
+	{Name: "-dylinker_install_name", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-compatibility_version", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-current_version", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-exported_symbols_list", Kind: OptionKind_Separate},
+	{Name: "-unexported_symbols_list", Kind: OptionKind_Separate},
+	{Name: "-filelist", Kind: OptionKind_Separate},
+	{Name: "-bundle_loader", Kind: OptionKind_Separate},
+	{Name: "-umbrella", Kind: OptionKind_Separate},
+	{Name: "-allowable_client", Kind: OptionKind_Separate},
+	{Name: "-sub_library", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-sub_umbrella", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-image_base", Kind: OptionKind_Separate},
+	{Name: "-init", Kind: OptionKind_Separate},
+	{Name: "-seg1addr", Kind: OptionKind_JoinedOrSeparate},
+	{Name: "-z", Kind: OptionKind_Separate},
+	// Pass-through to other tools
+	{Name: "-Xclang", Kind: OptionKind_Separate},
+	{Name: "-Xlinker", Kind: OptionKind_Separate},
+	{Name: "-Xassembler", Kind: OptionKind_Separate},
+	{Name: "-Xpreprocessor", Kind: OptionKind_Separate},
+	{Name: "-mllvm", Kind: OptionKind_Separate},
+	{Name: "-Wl,", Kind: OptionKind_CommaJoined},
+	{Name: "-Wa,", Kind: OptionKind_CommaJoined},
+	{Name: "-Wp,", Kind: OptionKind_CommaJoined},
+	// Conjunct's own options
+	{Name: "--conjunct-config-path", Kind: OptionKind_Separate},
+	{
+		Name:    "--conjunct-config-path=",
+		Kind:    OptionKind_Joined,
+		AliasOf: "--conjunct-config-path",
+	},
+	{Name: "--conjunct-verbose", Kind: OptionKind_Flag},
+	{Name: "--conjunct-dry-run", Kind: OptionKind_Flag},
+	{Name: "--conjunct-retain-temp-dir", Kind: OptionKind_Flag},
+	{Name: "--conjunct-no-cache", Kind: OptionKind_Flag},
+	{Name: "--conjunct-profile", Kind: OptionKind_Separate},
+	{
+		Name:    "--conjunct-profile=",
+		Kind:    OptionKind_Joined,
+		AliasOf: "--conjunct-profile",
+	},
+}
+
+var (
+	// optionsByName indexes 'options' by name
+	optionsByName = map[string]*Option{}
+	// optionsByLength is 'options' sorted from the longest name to the
+	// shortest, so that the longest matching option wins, like in clang
+	// (e.g., "-include-pch" is not "-include" with a "-pch" value)
+	optionsByLength = []*Option{}
+)
+
+func init() {
+	for i := range options {
+		optionsByName[options[i].Name] = &options[i]
+		optionsByLength = append(optionsByLength, &options[i])
+	}
+	sort.SliceStable(optionsByLength, func(i, j int) bool {
+		return len(optionsByLength[i].Name) > len(optionsByLength[j].Name)
+	})
+}
+
+// LookupOption returns the option spelled 'name' in the option table, or
+// nil if Conjunct doesn't know about it
+func LookupOption(name string) *Option {
+	return optionsByName[name]
+}
+
+// Arg is one parsed occurrence of an option or an input in a list of
+// arguments
+type Arg struct {
+	// Option is the option this argument is an occurrence of, with aliases
+	// resolved. It's nil for inputs and unknown options
+	Option *Option
+	// Spelling is the option as it was written (e.g., "--output=" for
+	// "--output=foo"), or the whole argument for inputs and unknown options
+	Spelling string
+	// Values are the values of the option, or the input itself for inputs.
+	// It's empty for flags and unknown options
+	Values []string
+	// Index is the index of the argument in the parsed list
+	Index int
+	// Count is the number of arguments this occurrence spans: 2 for
+	// separate options, 1 otherwise
+	Count int
+}
+
+// IsInput returns true if 'a' is an input (e.g., a source file), i.e., not
+// an option
+func (a *Arg) IsInput() bool {
+	return a.Option == nil && len(a.Values) != 0
+}
+
+// Parse parses 'args' into a list of options and inputs using the option
+// table. Everything after "--" is an input
+func Parse(args []string) []Arg {
+	parsedArgs := []Arg{}
+	for i := 0; i < len(args); {
+		if args[i] == "--" {
+			for j := i + 1; j < len(args); j++ {
+				parsedArgs = append(parsedArgs, Arg{
+					Spelling: args[j],
+					Values:   []string{args[j]},
+					Index:    j,
+					Count:    1,
+				})
+			}
+			break
+		}
+		parsedArg := parseArg(args, i)
+		parsedArgs = append(parsedArgs, parsedArg)
+		i += parsedArg.Count
+	}
+	return parsedArgs
+}
+
+// parseArg parses the argument at 'idx' in 'args', and its value if it's a
+// separate option
+func parseArg(args []string, idx int) Arg {
+	arg := args[idx]
+	if strings.HasPrefix(arg, "-") && len(arg) > 1 {
+		for _, opt := range optionsByLength {
+			parsedArg, ok := matchOption(opt, args, idx)
+			if ok {
+				return parsedArg
+			}
+		}
+	}
+	parsedArg := Arg{Spelling: arg, Index: idx, Count: 1}
+	if !strings.HasPrefix(arg, "-") || arg == "-" {
+		parsedArg.Values = []string{arg}
+	}
+	return parsedArg
+}
+
+// matchOption parses the argument at 'idx' in 'args' as an occurrence of
+// 'opt'. Returns false if it isn't one
+func matchOption(opt *Option, args []string, idx int) (Arg, bool) {
+	arg := args[idx]
+	canonicalOpt := opt
+	if len(opt.AliasOf) != 0 {
+		canonicalOpt = optionsByName[opt.AliasOf]
+	}
+	parsedArg := Arg{
+		Option:   canonicalOpt,
+		Spelling: opt.Name,
+		Index:    idx,
+		Count:    1,
+	}
+	isExact := arg == opt.Name
+	hasPrefix := strings.HasPrefix(arg, opt.Name)
+	switch opt.Kind {
+	case OptionKind_Flag:
+		return parsedArg, isExact
+	case OptionKind_Joined:
+		if !hasPrefix {
+			return Arg{}, false
+		}
+		parsedArg.Values = []string{arg[len(opt.Name):]}
+		return parsedArg, true
+	case OptionKind_CommaJoined:
+		if !hasPrefix {
+			return Arg{}, false
+		}
+		parsedArg.Values = strings.Split(arg[len(opt.Name):], ",")
+		return parsedArg, true
+	case OptionKind_Separate, OptionKind_JoinedOrSeparate:
+		if !isExact {
+			if opt.Kind == OptionKind_Separate || !hasPrefix {
+				return Arg{}, false
+			}
+			parsedArg.Values = []string{arg[len(opt.Name):]}
+			return parsedArg, true
+		}
+		if idx+1 < len(args) {
+			parsedArg.Values = []string{args[idx+1]}
+			parsedArg.Count = 2
+		}
+		return parsedArg, true
+	}
+	return Arg{}, false
+}
+
+// Inputs returns the inputs in 'args' (e.g., source files), in order. See
+// Arg.IsInput()
+func Inputs(args []string) []string {
+	inputs := []string{}
+	for _, parsedArg := range Parse(args) {
+		if parsedArg.IsInput() {
+			inputs = append(inputs, parsedArg.Spelling)
+		}
+	}
+	return inputs
+}
+
+// findOccurrences returns every occurrence of option 'opt' in 'args'
+func findOccurrences(args []string, opt *Option) []Arg {
+	canonicalName := opt.canonicalName()
+	occurrences := []Arg{}
+	for _, parsedArg := range Parse(args) {
+		if parsedArg.Option != nil && parsedArg.Option.Name == canonicalName {
+			occurrences = append(occurrences, parsedArg)
+		}
+	}
+	return occurrences
+}
@@ -0,0 +1,155 @@
+package argsparser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInputs(t *testing.T) {
+	var testcases = []struct {
+		name           string
+		inputArgs      []string
+		expectedRetval []string
+	}{
+		{
+			name:           "Values of options aren't inputs",
+			inputArgs:      []string{"-c", "hello.c", "-o", "foo.c", "-MF", "dep.c"},
+			expectedRetval: []string{"hello.c"},
+		},
+		{
+			name:           "Unknown options aren't inputs",
+			inputArgs:      []string{"-Wall", "-c", "a.c", "-fprofile-use=b.c", "c.c"},
+			expectedRetval: []string{"a.c", "c.c"},
+		},
+		{
+			name:           "Joined options don't take the next argument",
+			inputArgs:      []string{"-ofoo.o", "-c", "hello.c"},
+			expectedRetval: []string{"hello.c"},
+		},
+		{
+			name: "Values of separate linker and toolchain options aren't inputs",
+			inputArgs: []string{
+				"-framework", "Foundation", "-L", "lib", "-install_name", "libfoo.dylib",
+				"-object-file-name", "x.o", "-resource-dir", "res", "a.o",
+			},
+			expectedRetval: []string{"a.o"},
+		},
+		{
+			name: "Longer spellings of joined-or-separate options aren't joined values",
+			inputArgs: []string{
+				"-isystem-after", "/x", "-iframeworkwithsysroot", "/y", "-c", "a.c",
+			},
+			expectedRetval: []string{"a.c"},
+		},
+		{
+			name:           "Stdin is an input",
+			inputArgs:      []string{"-x", "c", "-c", "-"},
+			expectedRetval: []string{"-"},
+		},
+		{
+			name:           "Everything after -- is an input",
+			inputArgs:      []string{"-c", "--", "-weird.c"},
+			expectedRetval: []string{"-weird.c"},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expectedRetval, Inputs(tc.inputArgs))
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	parsedArgs := Parse([]string{
+		"-object", "-MFdep.d", "--output", "hello.o", "-Wl,a,b", "hello.c",
+	})
+	require.Len(t, parsedArgs, 5)
+
+	require.Equal(t, "-object", parsedArgs[0].Option.Name)
+	require.Empty(t, parsedArgs[0].Values)
+
+	require.Equal(t, "-MF", parsedArgs[1].Option.Name)
+	require.Equal(t, []string{"dep.d"}, parsedArgs[1].Values)
+
+	// Aliases resolve to their option but keep their spelling
+	require.Equal(t, "-o", parsedArgs[2].Option.Name)
+	require.Equal(t, "--output", parsedArgs[2].Spelling)
+	require.Equal(t, []string{"hello.o"}, parsedArgs[2].Values)
+	require.Equal(t, 2, parsedArgs[2].Count)
+
+	require.Equal(t, "-Wl,", parsedArgs[3].Option.Name)
+	require.Equal(t, []string{"a", "b"}, parsedArgs[3].Values)
+
+	require.True(t, parsedArgs[4].IsInput())
+	require.Equal(t, 5, parsedArgs[4].Index)
+}
+
+func TestParseOptionTable(t *testing.T) {
+	var testcases = []struct {
+		name           string
+		inputArg       string
+		expectedOption string
+		expectedValues []string
+	}{
+		{
+			name:           "-o joined",
+			inputArg:       "-ofoo.o",
+			expectedOption: "-o",
+			expectedValues: []string{"foo.o"},
+		},
+		{
+			name:           "-object-file-name= is not -o",
+			inputArg:       "-object-file-name=x.o",
+			expectedOption: "-object-file-name",
+			expectedValues: []string{"x.o"},
+		},
+		{
+			name:           "-O joined",
+			inputArg:       "-O2",
+			expectedOption: "-O",
+			expectedValues: []string{"2"},
+		},
+		{
+			name:           "-ObjC is not -O",
+			inputArg:       "-ObjC",
+			expectedOption: "-ObjC",
+		},
+		{
+			name:           "-ObjC++ is not -O",
+			inputArg:       "-ObjC++",
+			expectedOption: "-ObjC++",
+		},
+		{
+			name:           "-isystem-after is not -isystem",
+			inputArg:       "-isystem-after/x",
+			expectedOption: "-isystem-after",
+			expectedValues: []string{"/x"},
+		},
+		{
+			name:           "-iframeworkwithsysroot is not -iframework",
+			inputArg:       "-iframeworkwithsysroot/y",
+			expectedOption: "-iframeworkwithsysroot",
+			expectedValues: []string{"/y"},
+		},
+		{
+			name:           "-iwithprefixbefore is not -iwithprefix",
+			inputArg:       "-iwithprefixbefore/inc",
+			expectedOption: "-iwithprefixbefore",
+			expectedValues: []string{"/inc"},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			parsedArgs := Parse([]string{tc.inputArg})
+			require.Len(t, parsedArgs, 1)
+			require.NotNil(t, parsedArgs[0].Option)
+			require.Equal(t, tc.expectedOption, parsedArgs[0].Option.Name)
+			if len(tc.expectedValues) == 0 {
+				require.Empty(t, parsedArgs[0].Values)
+				return
+			}
+			require.Equal(t, tc.expectedValues, parsedArgs[0].Values)
+		})
+	}
+}
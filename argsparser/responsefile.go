@@ -0,0 +1,161 @@
+package argsparser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-playground/errors/v5"
+)
+
+// maxResponseFileDepth is how deep response files can include other response
+// files. It's there to catch cycles that go through different spellings of
+// the same path (e.g., symlinks)
+const maxResponseFileDepth = 32
+
+// ExpandResponseFiles replaces every "@path" argument in 'args' with the
+// arguments in the response file at 'path', like clang does:
+//   - Arguments are tokenized with TokenizeGNU()
+//   - Response files can include other response files. Relative paths of
+//     nested response files are relative to the directory of the response
+//     file that includes them
+//   - If 'path' doesn't exist, "@path" is left as is
+//
+// 'hasResponseFiles' is true if 'args' had at least one response file.
+// Returns an error if a response file can't be read or includes itself
+func ExpandResponseFiles(
+	args []string,
+) (expandedArgs []string, hasResponseFiles bool, err error) {
+	return expandResponseFiles(args, "", []string{})
+}
+
+// expandResponseFiles is ExpandResponseFiles() for 'args' read from a
+// response file in 'baseDir' (empty for the command line), with 'stack'
+// being the absolute paths of the response files being expanded
+func expandResponseFiles(
+	args []string,
+	baseDir string,
+	stack []string,
+) (expandedArgs []string, hasResponseFiles bool, err error) {
+	expandedArgs = []string{}
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "@") || len(arg) == 1 {
+			expandedArgs = append(expandedArgs, arg)
+			continue
+		}
+		path := arg[1:]
+		if !filepath.IsAbs(path) && len(baseDir) != 0 {
+			path = filepath.Join(baseDir, path)
+		}
+		content, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			expandedArgs = append(expandedArgs, arg)
+			continue
+		}
+		if err != nil {
+			return nil, false, errors.Wrapf(err, "while reading response file %s", path)
+		}
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, false, errors.Wrapf(err, "while resolving %s", path)
+		}
+		for _, p := range stack {
+			if p == absPath {
+				return nil, false, errors.Newf(
+					"response file %s includes itself", path,
+				)
+			}
+		}
+		if len(stack) >= maxResponseFileDepth {
+			return nil, false, errors.Newf(
+				"response files nested too deep at %s", path,
+			)
+		}
+		nestedArgs, _, err := expandResponseFiles(
+			TokenizeGNU(string(content)),
+			filepath.Dir(absPath),
+			append(stack, absPath),
+		)
+		if err != nil {
+			return nil, false, err
+		}
+		expandedArgs = append(expandedArgs, nestedArgs...)
+		hasResponseFiles = true
+	}
+	return expandedArgs, hasResponseFiles, nil
+}
+
+// TokenizeGNU splits 's' into arguments using the GNU quoting rules clang
+// uses for response files on non-Windows hosts:
+//   - Arguments are separated by whitespace
+//   - A backslash escapes the next character, inside quotes too
+//   - Single and double quotes group characters, including whitespace, into
+//     one argument
+//
+// XXX Like clang, an empty quoted string ("") produces no argument
+func TokenizeGNU(s string) []string {
+	args := []string{}
+	token := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			token.WriteByte(s[i])
+		case c == '\'' || c == '"':
+			for i++; i < len(s) && s[i] != c; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				token.WriteByte(s[i])
+			}
+		case isGNUWhitespace(c):
+			if token.Len() != 0 {
+				args = append(args, token.String())
+				token.Reset()
+			}
+		default:
+			token.WriteByte(c)
+		}
+	}
+	if token.Len() != 0 {
+		args = append(args, token.String())
+	}
+	return args
+}
+
+func isGNUWhitespace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+// QuoteGNU quotes 'arg' so that TokenizeGNU() reads it back as one argument
+func QuoteGNU(arg string) string {
+	if len(arg) != 0 && !strings.ContainsAny(arg, " \t\n\r\v\f'\"\\") {
+		return arg
+	}
+	quotedArg := strings.Builder{}
+	quotedArg.WriteByte('"')
+	for i := 0; i < len(arg); i++ {
+		if arg[i] == '"' || arg[i] == '\\' {
+			quotedArg.WriteByte('\\')
+		}
+		quotedArg.WriteByte(arg[i])
+	}
+	quotedArg.WriteByte('"')
+	return quotedArg.String()
+}
+
+// WriteResponseFile writes 'args' to a response file at 'path', one quoted
+// argument per line
+func WriteResponseFile(path string, args []string) error {
+	content := strings.Builder{}
+	for _, arg := range args {
+		content.WriteString(QuoteGNU(arg))
+		content.WriteByte('\n')
+	}
+	err := os.WriteFile(path, []byte(content.String()), 0644)
+	if err != nil {
+		return errors.Wrapf(err, "while writing response file %s", path)
+	}
+	return nil
+}
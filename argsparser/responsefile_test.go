@@ -0,0 +1,125 @@
+package argsparser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenizeGNU(t *testing.T) {
+	var testcases = []struct {
+		name           string
+		input          string
+		expectedRetval []string
+	}{
+		{
+			name:           "Whitespace",
+			input:          "-c  hello.c\n-o\thello.o\n",
+			expectedRetval: []string{"-c", "hello.c", "-o", "hello.o"},
+		},
+		{
+			name:           "Double quotes",
+			input:          `-DFOO="a b" "-I/my dir"`,
+			expectedRetval: []string{"-DFOO=a b", "-I/my dir"},
+		},
+		{
+			name:           "Single quotes",
+			input:          `'-DFOO="bar"'`,
+			expectedRetval: []string{`-DFOO="bar"`},
+		},
+		{
+			name:           "Backslash escapes",
+			input:          `-I/my\ dir "a\"b" c\\d`,
+			expectedRetval: []string{"-I/my dir", `a"b`, `c\d`},
+		},
+		{
+			name:           "Empty quotes produce nothing",
+			input:          `-c "" hello.c`,
+			expectedRetval: []string{"-c", "hello.c"},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expectedRetval, TokenizeGNU(tc.input))
+		})
+	}
+}
+
+func TestWriteResponseFile(t *testing.T) {
+	args := []string{
+		"-c", "hello.c", "-DFOO=\"a b\"", "-I/my dir", `c:\windows`, "it's",
+	}
+	responseFilePath := filepath.Join(t.TempDir(), "args.rsp")
+	err := WriteResponseFile(responseFilePath, args)
+	require.NoError(t, err)
+	expandedArgs, hasResponseFiles, err := ExpandResponseFiles(
+		[]string{"@" + responseFilePath},
+	)
+	require.NoError(t, err)
+	require.True(t, hasResponseFiles)
+	require.Equal(t, args, expandedArgs)
+}
+
+func TestExpandResponseFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	nestedDir := filepath.Join(tempDir, "nested")
+	require.NoError(t, os.Mkdir(nestedDir, 0755))
+	writeFile := func(path, content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	writeFile(filepath.Join(tempDir, "top.rsp"), "-c hello.c @nested/inner.rsp")
+	// Relative to the including file, not to the working directory
+	writeFile(filepath.Join(nestedDir, "inner.rsp"), "-o hello.o @innermost.rsp")
+	writeFile(filepath.Join(nestedDir, "innermost.rsp"), "-O2")
+	writeFile(filepath.Join(tempDir, "cycle.rsp"), "-c @cycle.rsp")
+
+	var testcases = []struct {
+		name                     string
+		inputArgs                []string
+		expectedArgs             []string
+		expectedHasResponseFiles bool
+		expectedError            bool
+	}{
+		{
+			name:                     "No response files",
+			inputArgs:                []string{"-c", "hello.c"},
+			expectedArgs:             []string{"-c", "hello.c"},
+			expectedHasResponseFiles: false,
+		},
+		{
+			name: "Nested response files",
+			inputArgs: []string{
+				"-Wall", "@" + filepath.Join(tempDir, "top.rsp"), "-g",
+			},
+			expectedArgs: []string{
+				"-Wall", "-c", "hello.c", "-o", "hello.o", "-O2", "-g",
+			},
+			expectedHasResponseFiles: true,
+		},
+		{
+			name:                     "Missing response file is left as is",
+			inputArgs:                []string{"-c", "@/does/not/exist.rsp"},
+			expectedArgs:             []string{"-c", "@/does/not/exist.rsp"},
+			expectedHasResponseFiles: false,
+		},
+		{
+			name:          "Cycle",
+			inputArgs:     []string{"@" + filepath.Join(tempDir, "cycle.rsp")},
+			expectedError: true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			actualArgs, hasResponseFiles, err := ExpandResponseFiles(tc.inputArgs)
+			if tc.expectedError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedArgs, actualArgs)
+			require.Equal(t, tc.expectedHasResponseFiles, hasResponseFiles)
+		})
+	}
+}
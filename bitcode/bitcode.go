@@ -0,0 +1,226 @@
+package bitcode
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/go-playground/errors/v5"
+)
+
+const (
+	blockID_Module         = 8
+	blockID_Metadata       = 15
+	blockID_Identification = 13
+
+	metadataCode_Name = 4
+	moduleCode_Triple = 2
+	// debugInfoMetadataName is the named metadata that lists the compile
+	// units of a module with debug info
+	debugInfoMetadataName = "llvm.dbg.cu"
+
+	identificationCode_String = 1
+	identificationCode_Epoch  = 2
+
+	// CurrentEpoch is the bitcode epoch of every LLVM release since LLVM 4.
+	// Readers only accept bitcode with the same epoch.
+	CurrentEpoch = 0
+)
+
+var (
+	// Matches producers like "LLVM17.0.6" or "LLVM17.0.6git"
+	llvmProducerRegex = regexp.MustCompile(`^LLVM(\d+)\.`)
+	// Matches producers like "APPLE_1_1500.3.9.4_0"
+	appleProducerRegex = regexp.MustCompile(`^APPLE_\d+_(\d+)\.`)
+	// Matches the target triple of textual IR
+	textualTripleRegex = regexp.MustCompile(`(?m)^target triple = "([^"]*)"`)
+
+	// appleToLLVMMajor maps the first Apple clang version of a release to
+	// the upstream LLVM major version it's based on.
+	// Ref: https://en.wikipedia.org/wiki/Xcode#Toolchain_versions
+	appleToLLVMMajor = []struct {
+		appleVersion int
+		llvmMajor    int
+	}{
+		{1000, 6},
+		{1001, 7},
+		{1100, 8},
+		{1200, 10},
+		{1205, 11},
+		{1300, 12},
+		{1316, 13},
+		{1400, 14},
+		{1403, 15},
+		{1500, 16},
+		{1600, 17},
+		{1700, 19},
+	}
+)
+
+// Identification is the content of the IDENTIFICATION block of a bitcode
+// file
+type Identification struct {
+	// Producer is the producer string, e.g., "LLVM17.0.6" or
+	// "APPLE_1_1500.3.9.4_0"
+	Producer string
+	// Epoch is the bitcode epoch
+	Epoch uint64
+}
+
+// ReadIdentification reads the IDENTIFICATION block of the bitcode file at
+// 'path'. Returns nil if the file has no IDENTIFICATION block, which is the
+// case for bitcode produced before LLVM 4.
+func ReadIdentification(path string) (*Identification, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while reading %s", path)
+	}
+	var ident *Identification
+	err = walk(data, &walker{
+		enterBlock: func(blockID uint64) bool {
+			if blockID == blockID_Identification {
+				ident = &Identification{}
+				return true
+			}
+			// The IDENTIFICATION block is always first: stop at any other
+			// block
+			return false
+		},
+		visitRecord: func(blockID uint64, rec *Record) error {
+			switch rec.Code {
+			case identificationCode_String:
+				ident.Producer = rec.String()
+			case identificationCode_Epoch:
+				if len(rec.Ops) != 0 {
+					ident.Epoch = rec.Ops[0]
+				}
+				// The epoch is the last record of the block
+				return errStopWalk
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "while reading bitcode %s", path)
+	}
+	return ident, nil
+}
+
+// LLVMMajorVersion returns the upstream LLVM major version of the producer
+// of 'ident'. Returns false if the producer is unknown.
+func (ident *Identification) LLVMMajorVersion() (int, bool) {
+	if m := llvmProducerRegex.FindStringSubmatch(ident.Producer); m != nil {
+		major, err := strconv.Atoi(m[1])
+		return major, err == nil
+	}
+	if m := appleProducerRegex.FindStringSubmatch(ident.Producer); m != nil {
+		appleVersion, err := strconv.Atoi(m[1])
+		if err != nil {
+			return 0, false
+		}
+		idx := sort.Search(len(appleToLLVMMajor), func(i int) bool {
+			return appleToLLVMMajor[i].appleVersion > appleVersion
+		})
+		if idx == 0 {
+			return 0, false
+		}
+		return appleToLLVMMajor[idx-1].llvmMajor, true
+	}
+	return 0, false
+}
+
+// CheckCompatibility returns an error if a reader from LLVM 'readerMajor'
+// (e.g., opt) can't read bitcode identified by 'ident'. Bitcode is
+// backwards compatible: readers accept bitcode from the same or older LLVM
+// versions with the same epoch.
+//
+// 'isKnown' is false if the producer is unknown, in which case the
+// compatibility can't be fully checked.
+func (ident *Identification) CheckCompatibility(
+	readerMajor int,
+) (isKnown bool, err error) {
+	if ident.Epoch != CurrentEpoch {
+		return true, errors.Newf(
+			"bitcode epoch %d (producer %s) doesn't match reader epoch %d",
+			ident.Epoch,
+			ident.Producer,
+			CurrentEpoch,
+		)
+	}
+	producerMajor, ok := ident.LLVMMajorVersion()
+	if !ok {
+		return false, nil
+	}
+	if producerMajor > readerMajor {
+		return true, errors.Newf(
+			"bitcode produced by %s (LLVM %d) can't be read by LLVM %d",
+			ident.Producer,
+			producerMajor,
+			readerMajor,
+		)
+	}
+	return true, nil
+}
+
+// HasDebugInfo returns true if the bitcode file at 'path' has debug info,
+// i.e., an 'llvm.dbg.cu' named metadata
+func HasDebugInfo(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, errors.Wrapf(err, "while reading %s", path)
+	}
+	hasDebugInfo := false
+	err = walk(data, &walker{
+		enterBlock: func(blockID uint64) bool {
+			// Module-level metadata lives directly in the module block
+			return blockID == blockID_Module || blockID == blockID_Metadata
+		},
+		visitRecord: func(blockID uint64, rec *Record) error {
+			if blockID == blockID_Metadata &&
+				rec.Code == metadataCode_Name &&
+				rec.String() == debugInfoMetadataName {
+				hasDebugInfo = true
+				return errStopWalk
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "while reading bitcode %s", path)
+	}
+	return hasDebugInfo, nil
+}
+
+// ReadTargetTriple returns the target triple of the module in the bitcode or
+// textual IR file at 'path' (e.g., "arm64-apple-ios17.0.0"). Returns an
+// empty string if the module has none
+func ReadTargetTriple(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "while reading %s", path)
+	}
+	if !isBitcode(data) {
+		if m := textualTripleRegex.FindSubmatch(data); m != nil {
+			return string(m[1]), nil
+		}
+		return "", nil
+	}
+	triple := ""
+	err = walk(data, &walker{
+		enterBlock: func(blockID uint64) bool {
+			return blockID == blockID_Module
+		},
+		visitRecord: func(blockID uint64, rec *Record) error {
+			if blockID == blockID_Module && rec.Code == moduleCode_Triple {
+				triple = rec.String()
+				return errStopWalk
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "while reading bitcode %s", path)
+	}
+	return triple, nil
+}
@@ -0,0 +1,227 @@
+package bitcode
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/afjoseph/conjunct/projectpath"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadIdentification(t *testing.T) {
+	llvmAsPath, err := exec.LookPath("llvm-as")
+	require.NoError(t, err)
+	// Assemble a bitcode file without Apple's wrapper header
+	unwrappedPath := filepath.Join(t.TempDir(), "hello.bc")
+	err = exec.Command(
+		llvmAsPath,
+		filepath.Join(projectpath.Root, "testassets/unit/hello.ll"),
+		"-o", unwrappedPath,
+	).Run()
+	require.NoError(t, err)
+
+	var testcases = []struct {
+		name              string
+		inputPath         string
+		expectedProducer  string
+		expectedLLVMMajor int
+		expectedError     bool
+	}{
+		{
+			name: "Bitcode with a wrapper header",
+			inputPath: filepath.Join(
+				projectpath.Root,
+				"testassets/unit/hello.bc",
+			),
+			expectedProducer:  "LLVM17.0.6",
+			expectedLLVMMajor: 17,
+		},
+		{
+			name:             "Bitcode without a wrapper header",
+			inputPath:        unwrappedPath,
+			expectedProducer: "LLVM",
+		},
+		{
+			name:          "Not bitcode",
+			inputPath:     filepath.Join(projectpath.Root, "testassets/unit/hello.c"),
+			expectedError: true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ident, err := ReadIdentification(tc.inputPath)
+			if tc.expectedError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.NotNil(t, ident)
+			require.Contains(t, ident.Producer, tc.expectedProducer)
+			require.Equal(t, uint64(CurrentEpoch), ident.Epoch)
+			if tc.expectedLLVMMajor != 0 {
+				major, ok := ident.LLVMMajorVersion()
+				require.True(t, ok)
+				require.Equal(t, tc.expectedLLVMMajor, major)
+			}
+		})
+	}
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	var testcases = []struct {
+		name            string
+		inputIdent      Identification
+		inputReader     int
+		expectedIsKnown bool
+		expectedError   bool
+	}{
+		{
+			name:            "Same version",
+			inputIdent:      Identification{Producer: "LLVM14.0.6"},
+			inputReader:     14,
+			expectedIsKnown: true,
+		},
+		{
+			name:            "Older producer",
+			inputIdent:      Identification{Producer: "LLVM12.0.1"},
+			inputReader:     14,
+			expectedIsKnown: true,
+		},
+		{
+			name:            "Newer producer",
+			inputIdent:      Identification{Producer: "LLVM17.0.6"},
+			inputReader:     14,
+			expectedIsKnown: true,
+			expectedError:   true,
+		},
+		{
+			name:            "Apple producer",
+			inputIdent:      Identification{Producer: "APPLE_1_1500.3.9.4_0"},
+			inputReader:     16,
+			expectedIsKnown: true,
+		},
+		{
+			name:            "Newer Apple producer",
+			inputIdent:      Identification{Producer: "APPLE_1_1500.3.9.4_0"},
+			inputReader:     15,
+			expectedIsKnown: true,
+			expectedError:   true,
+		},
+		{
+			name:            "Unknown producer",
+			inputIdent:      Identification{Producer: "rustc"},
+			inputReader:     14,
+			expectedIsKnown: false,
+		},
+		{
+			name: "Different epoch",
+			inputIdent: Identification{
+				Producer: "LLVM14.0.6",
+				Epoch:    1,
+			},
+			inputReader:     14,
+			expectedIsKnown: true,
+			expectedError:   true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			isKnown, err := tc.inputIdent.CheckCompatibility(tc.inputReader)
+			require.Equal(t, tc.expectedIsKnown, isKnown)
+			if tc.expectedError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestHasDebugInfo(t *testing.T) {
+	llvmAsPath, err := exec.LookPath("llvm-as")
+	require.NoError(t, err)
+	tempDir := t.TempDir()
+
+	var testcases = []struct {
+		name           string
+		inputIRPath    string
+		expectedRetval bool
+	}{
+		{
+			name:           "Module with debug info",
+			inputIRPath:    "testassets/unit/hello_debug.ll",
+			expectedRetval: true,
+		},
+		{
+			name:           "Module without debug info",
+			inputIRPath:    "testassets/unit/hello.ll",
+			expectedRetval: false,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			bitcodePath := filepath.Join(tempDir, filepath.Base(tc.inputIRPath)+".bc")
+			err := exec.Command(
+				llvmAsPath,
+				filepath.Join(projectpath.Root, tc.inputIRPath),
+				"-o", bitcodePath,
+			).Run()
+			require.NoError(t, err)
+			hasDebugInfo, err := HasDebugInfo(bitcodePath)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedRetval, hasDebugInfo)
+		})
+	}
+}
+
+func TestReadTargetTriple(t *testing.T) {
+	llvmAsPath, err := exec.LookPath("llvm-as")
+	require.NoError(t, err)
+	tempDir := t.TempDir()
+
+	var testcases = []struct {
+		name           string
+		inputIR        string
+		isBitcode      bool
+		expectedTriple string
+	}{
+		{
+			name:           "Bitcode with a triple",
+			inputIR:        "target triple = \"arm64-apple-ios17.0.0\"\n",
+			isBitcode:      true,
+			expectedTriple: "arm64-apple-ios17.0.0",
+		},
+		{
+			name:           "Bitcode without a triple",
+			inputIR:        "source_filename = \"hello.c\"\n",
+			isBitcode:      true,
+			expectedTriple: "",
+		},
+		{
+			name: "Textual IR with a triple",
+			inputIR: "source_filename = \"hello.c\"\n" +
+				"target triple = \"x86_64-unknown-linux-gnu\"\n",
+			isBitcode:      false,
+			expectedTriple: "x86_64-unknown-linux-gnu",
+		},
+	}
+	for i, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			irPath := filepath.Join(tempDir, fmt.Sprintf("%d.ll", i))
+			err := os.WriteFile(irPath, []byte(tc.inputIR), 0644)
+			require.NoError(t, err)
+			inputPath := irPath
+			if tc.isBitcode {
+				inputPath = irPath + ".bc"
+				err = exec.Command(llvmAsPath, irPath, "-o", inputPath).Run()
+				require.NoError(t, err)
+			}
+			triple, err := ReadTargetTriple(inputPath)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedTriple, triple)
+		})
+	}
+}
@@ -0,0 +1,397 @@
+package bitcode
+
+import (
+	"encoding/binary"
+	stderr "errors"
+
+	"github.com/go-playground/errors/v5"
+)
+
+// This file implements a minimal reader for the LLVM bitstream container
+// format. Ref: https://llvm.org/docs/BitCodeFormat.html
+
+// Builtin abbreviation IDs
+const (
+	abbrevID_EndBlock       = 0
+	abbrevID_EnterSubblock  = 1
+	abbrevID_DefineAbbrev   = 2
+	abbrevID_UnabbrevRecord = 3
+	abbrevID_FirstUserID    = 4
+)
+
+// Encodings of abbreviation operands
+const (
+	abbrevOpKind_Literal = 0
+	abbrevOpKind_Fixed   = 1
+	abbrevOpKind_VBR     = 2
+	abbrevOpKind_Array   = 3
+	abbrevOpKind_Char6   = 4
+	abbrevOpKind_Blob    = 5
+)
+
+const (
+	blockID_BlockInfo = 0
+	// Record code in the BLOCKINFO block that sets the block the following
+	// abbreviations apply to
+	blockInfoCode_SetBID = 1
+)
+
+var (
+	errUnexpectedEOF = stderr.New("unexpected end of bitstream")
+	// errStopWalk stops walk() early without returning an error
+	errStopWalk = stderr.New("stop walk")
+)
+
+type abbrevOp struct {
+	kind  int
+	value uint64
+}
+
+type abbrev []abbrevOp
+
+// Record is a single record in a block
+type Record struct {
+	Code uint64
+	Ops  []uint64
+	Blob []byte
+}
+
+// String returns the operands of 'rec' as a string of characters
+func (rec *Record) String() string {
+	b := make([]byte, 0, len(rec.Ops))
+	for _, op := range rec.Ops {
+		b = append(b, byte(op))
+	}
+	return string(b)
+}
+
+// bitReader reads a bitstream: bits are read from the least significant bit
+// of each byte first
+type bitReader struct {
+	data []byte
+	// pos is the current position in bits
+	pos uint64
+	// blockInfo holds the abbreviations defined in BLOCKINFO blocks, per
+	// block ID
+	blockInfo map[uint64][]abbrev
+}
+
+func (r *bitReader) totalBits() uint64 {
+	return uint64(len(r.data)) * 8
+}
+
+func (r *bitReader) readFixed(width uint) (uint64, error) {
+	if width > 64 {
+		return 0, errors.Newf("bad fixed width %d", width)
+	}
+	if r.pos+uint64(width) > r.totalBits() {
+		return 0, errUnexpectedEOF
+	}
+	val := uint64(0)
+	for i := uint(0); i < width; {
+		byteIdx := r.pos / 8
+		bitIdx := uint(r.pos % 8)
+		n := 8 - bitIdx
+		if n > width-i {
+			n = width - i
+		}
+		bits := (uint64(r.data[byteIdx]) >> bitIdx) & ((1 << n) - 1)
+		val |= bits << i
+		i += n
+		r.pos += uint64(n)
+	}
+	return val, nil
+}
+
+func (r *bitReader) readVBR(width uint) (uint64, error) {
+	if width < 2 || width > 32 {
+		return 0, errors.Newf("bad VBR width %d", width)
+	}
+	hiBit := uint64(1) << (width - 1)
+	val := uint64(0)
+	for shift := uint(0); ; shift += width - 1 {
+		if shift >= 64 {
+			return 0, errors.New("VBR value too large")
+		}
+		chunk, err := r.readFixed(width)
+		if err != nil {
+			return 0, err
+		}
+		val |= (chunk &^ hiBit) << shift
+		if chunk&hiBit == 0 {
+			return val, nil
+		}
+	}
+}
+
+func (r *bitReader) align32() {
+	r.pos = (r.pos + 31) &^ 31
+}
+
+func (r *bitReader) readAbbrev() (abbrev, error) {
+	numOps, err := r.readVBR(5)
+	if err != nil {
+		return nil, err
+	}
+	a := abbrev{}
+	for i := uint64(0); i < numOps; i++ {
+		isLiteral, err := r.readFixed(1)
+		if err != nil {
+			return nil, err
+		}
+		if isLiteral == 1 {
+			val, err := r.readVBR(8)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, abbrevOp{kind: abbrevOpKind_Literal, value: val})
+			continue
+		}
+		kind, err := r.readFixed(3)
+		if err != nil {
+			return nil, err
+		}
+		op := abbrevOp{kind: int(kind)}
+		switch op.kind {
+		case abbrevOpKind_Fixed, abbrevOpKind_VBR:
+			op.value, err = r.readVBR(5)
+			if err != nil {
+				return nil, err
+			}
+		case abbrevOpKind_Array, abbrevOpKind_Char6, abbrevOpKind_Blob:
+		default:
+			return nil, errors.Newf("bad abbreviation operand kind %d", kind)
+		}
+		a = append(a, op)
+	}
+	return a, nil
+}
+
+func (r *bitReader) readScalar(op abbrevOp) (uint64, error) {
+	switch op.kind {
+	case abbrevOpKind_Literal:
+		return op.value, nil
+	case abbrevOpKind_Fixed:
+		return r.readFixed(uint(op.value))
+	case abbrevOpKind_VBR:
+		return r.readVBR(uint(op.value))
+	case abbrevOpKind_Char6:
+		v, err := r.readFixed(6)
+		if err != nil {
+			return 0, err
+		}
+		const char6 = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789._"
+		return uint64(char6[v]), nil
+	}
+	return 0, errors.Newf("bad scalar operand kind %d", op.kind)
+}
+
+func (r *bitReader) readAbbrevRecord(a abbrev) (*Record, error) {
+	vals := []uint64{}
+	var blob []byte
+	for i := 0; i < len(a); i++ {
+		op := a[i]
+		switch op.kind {
+		case abbrevOpKind_Array:
+			if i+1 >= len(a) {
+				return nil, errors.New("array operand without element type")
+			}
+			numElts, err := r.readVBR(6)
+			if err != nil {
+				return nil, err
+			}
+			i++
+			for j := uint64(0); j < numElts; j++ {
+				v, err := r.readScalar(a[i])
+				if err != nil {
+					return nil, err
+				}
+				vals = append(vals, v)
+			}
+		case abbrevOpKind_Blob:
+			numBytes, err := r.readVBR(6)
+			if err != nil {
+				return nil, err
+			}
+			r.align32()
+			start := r.pos / 8
+			if start+numBytes > uint64(len(r.data)) {
+				return nil, errUnexpectedEOF
+			}
+			blob = r.data[start : start+numBytes]
+			r.pos += numBytes * 8
+			r.align32()
+		default:
+			v, err := r.readScalar(op)
+			if err != nil {
+				return nil, err
+			}
+			vals = append(vals, v)
+		}
+	}
+	if len(vals) == 0 {
+		return nil, errors.New("abbreviated record without a code")
+	}
+	return &Record{Code: vals[0], Ops: vals[1:], Blob: blob}, nil
+}
+
+func (r *bitReader) readUnabbrevRecord() (*Record, error) {
+	code, err := r.readVBR(6)
+	if err != nil {
+		return nil, err
+	}
+	numOps, err := r.readVBR(6)
+	if err != nil {
+		return nil, err
+	}
+	rec := &Record{Code: code}
+	for i := uint64(0); i < numOps; i++ {
+		op, err := r.readVBR(6)
+		if err != nil {
+			return nil, err
+		}
+		rec.Ops = append(rec.Ops, op)
+	}
+	return rec, nil
+}
+
+// walker holds the callbacks of walk()
+type walker struct {
+	// enterBlock returns true if the block with 'blockID' should be read.
+	// Else, the block is skipped
+	enterBlock func(blockID uint64) bool
+	// visitRecord is called for every record in a block that was entered.
+	// Returning errStopWalk stops the walk
+	visitRecord func(blockID uint64, rec *Record) error
+}
+
+// readBlock reads the content of the block 'blockID', up to its END_BLOCK.
+// If 'isTopLevel' is true, it reads until the end of the stream instead.
+func (r *bitReader) readBlock(
+	w *walker,
+	blockID uint64,
+	abbrevWidth uint,
+	isTopLevel bool,
+) error {
+	abbrevs := append([]abbrev(nil), r.blockInfo[blockID]...)
+	curBlockInfoID := uint64(0)
+	hasCurBlockInfoID := false
+	for {
+		if isTopLevel && r.pos+uint64(abbrevWidth) > r.totalBits() {
+			return nil
+		}
+		id, err := r.readFixed(abbrevWidth)
+		if err != nil {
+			return err
+		}
+		switch id {
+		case abbrevID_EndBlock:
+			r.align32()
+			return nil
+		case abbrevID_EnterSubblock:
+			subBlockID, err := r.readVBR(8)
+			if err != nil {
+				return err
+			}
+			subAbbrevWidth, err := r.readVBR(4)
+			if err != nil {
+				return err
+			}
+			r.align32()
+			numWords, err := r.readFixed(32)
+			if err != nil {
+				return err
+			}
+			endPos := r.pos + numWords*32
+			if endPos > r.totalBits() {
+				return errUnexpectedEOF
+			}
+			if subBlockID != blockID_BlockInfo && !w.enterBlock(subBlockID) {
+				r.pos = endPos
+				continue
+			}
+			err = r.readBlock(w, subBlockID, uint(subAbbrevWidth), false)
+			if err != nil {
+				return err
+			}
+		case abbrevID_DefineAbbrev:
+			a, err := r.readAbbrev()
+			if err != nil {
+				return err
+			}
+			if blockID != blockID_BlockInfo {
+				abbrevs = append(abbrevs, a)
+				continue
+			}
+			if !hasCurBlockInfoID {
+				return errors.New("DEFINE_ABBREV in BLOCKINFO before SETBID")
+			}
+			r.blockInfo[curBlockInfoID] = append(r.blockInfo[curBlockInfoID], a)
+		default:
+			var rec *Record
+			if id == abbrevID_UnabbrevRecord {
+				rec, err = r.readUnabbrevRecord()
+			} else if int(id-abbrevID_FirstUserID) < len(abbrevs) {
+				rec, err = r.readAbbrevRecord(abbrevs[id-abbrevID_FirstUserID])
+			} else {
+				err = errors.Newf("unknown abbreviation ID %d in block %d", id, blockID)
+			}
+			if err != nil {
+				return err
+			}
+			if blockID == blockID_BlockInfo {
+				if rec.Code == blockInfoCode_SetBID && len(rec.Ops) != 0 {
+					curBlockInfoID = rec.Ops[0]
+					hasCurBlockInfoID = true
+				}
+				continue
+			}
+			if err := w.visitRecord(blockID, rec); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+const (
+	wrapperMagic  = 0x0B17C0DE
+	bitcodeMagic  = 0xDEC04342 // 'B', 'C', 0xC0, 0xDE in little endian
+	wrapperHeader = 20
+)
+
+// isBitcode returns true if 'data' starts like a bitcode file, with or
+// without a wrapper header
+func isBitcode(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+	magic := binary.LittleEndian.Uint32(data)
+	return magic == bitcodeMagic || magic == wrapperMagic
+}
+
+// walk walks the bitcode in 'data' using the callbacks in 'w'
+func walk(data []byte, w *walker) error {
+	// Strip the wrapper header that Apple toolchains emit
+	if len(data) >= wrapperHeader &&
+		binary.LittleEndian.Uint32(data) == wrapperMagic {
+		offset := uint64(binary.LittleEndian.Uint32(data[8:]))
+		size := uint64(binary.LittleEndian.Uint32(data[12:]))
+		if offset+size > uint64(len(data)) {
+			return errors.New("bad bitcode wrapper header")
+		}
+		data = data[offset : offset+size]
+	}
+	if len(data) < 4 || binary.LittleEndian.Uint32(data) != bitcodeMagic {
+		return errors.New("not an LLVM bitcode file")
+	}
+	r := &bitReader{
+		data:      data,
+		pos:       32,
+		blockInfo: map[uint64][]abbrev{},
+	}
+	err := r.readBlock(w, ^uint64(0), 2, true)
+	if stderr.Is(err, errStopWalk) {
+		return nil
+	}
+	return err
+}
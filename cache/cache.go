@@ -0,0 +1,277 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/afjoseph/conjunct/config"
+	"github.com/go-playground/errors/v5"
+	"github.com/sirupsen/logrus"
+)
+
+const entryExtension = ".bc"
+
+// Cache is an on-disk, content-addressed cache of transformed bitcode.
+// Entries are keyed with Key() and evicted, least recently used first, when
+// the cache grows above its maximum size.
+type Cache struct {
+	dir string
+	// maxSize is the maximum size of the cache in bytes. 0 means unbounded
+	maxSize int64
+}
+
+// New returns a Cache in 'dir', creating it if needed. 'maxSize' is in bytes
+// and 0 means unbounded.
+func New(dir string, maxSize int64) (*Cache, error) {
+	if len(dir) == 0 {
+		return nil, errors.New("empty cache dir")
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while creating cache dir %s", dir)
+	}
+	return &Cache{dir: dir, maxSize: maxSize}, nil
+}
+
+// Get copies the entry for 'key' to 'dstPath'. Returns false if there's no
+// such entry.
+func (c *Cache) Get(key string, dstPath string) (bool, error) {
+	entryPath := c.entryPath(key)
+	if _, err := os.Stat(entryPath); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "while checking %s", entryPath)
+	}
+	err := copyFile(entryPath, dstPath)
+	if err != nil {
+		// The entry could've been evicted in the meantime
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "while reading cache entry %s", key)
+	}
+	// Mark the entry as recently used for eviction
+	now := time.Now()
+	_ = os.Chtimes(entryPath, now, now)
+	return true, nil
+}
+
+// Put stores 'srcPath' as the entry for 'key' and evicts old entries if the
+// cache grew above its maximum size
+func (c *Cache) Put(key string, srcPath string) error {
+	// Write to a temporary file first and rename it so that concurrent
+	// invocations never read a partially-written entry
+	tmpFile, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		return errors.Wrapf(err, "while creating temp file")
+	}
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+	err = copyFile(srcPath, tmpFile.Name())
+	if err != nil {
+		return errors.Wrapf(err, "while writing cache entry %s", key)
+	}
+	err = os.Rename(tmpFile.Name(), c.entryPath(key))
+	if err != nil {
+		return errors.Wrapf(err, "while writing cache entry %s", key)
+	}
+	return c.evict()
+}
+
+// evict removes the least recently used entries until the cache is below
+// its maximum size
+func (c *Cache) evict() error {
+	if c.maxSize <= 0 {
+		return nil
+	}
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return errors.Wrapf(err, "while reading cache dir %s", c.dir)
+	}
+	entries := []os.FileInfo{}
+	totalSize := int64(0)
+	for _, dirEntry := range dirEntries {
+		if filepath.Ext(dirEntry.Name()) != entryExtension {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			// Evicted by a concurrent invocation
+			continue
+		}
+		entries = append(entries, info)
+		totalSize += info.Size()
+	}
+	if totalSize <= c.maxSize {
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime().Before(entries[j].ModTime())
+	})
+	for _, info := range entries {
+		if totalSize <= c.maxSize {
+			break
+		}
+		logrus.Debugf("Evicting cache entry %s", info.Name())
+		err := os.Remove(filepath.Join(c.dir, info.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "while evicting %s", info.Name())
+		}
+		totalSize -= info.Size()
+	}
+	return nil
+}
+
+func (c *Cache) entryPath(key string) string {
+	return filepath.Join(c.dir, key+entryExtension)
+}
+
+// Key returns the cache key of running 'stages' on 'bitcodeFilepath'. The
+// key covers the content of the bitcode, 'commonEnvVars' (i.e., the env vars
+// every stage gets, like the seed), the binary each stage runs, the stage's
+// args and env vars and the content of the files the stage reads besides
+// its input (see getStageInputs())
+func Key(
+	bitcodeFilepath string,
+	stages []config.Stage,
+	commonEnvVars map[string]string,
+) (string, error) {
+	h := sha256.New()
+	bitcodeHash, err := HashFile(bitcodeFilepath)
+	if err != nil {
+		return "", errors.Wrapf(err, "while hashing bitcode")
+	}
+	writeField(h, "bitcode", bitcodeHash)
+	writeEnvVars(h, "common-env", commonEnvVars)
+	for i, stage := range stages {
+		writeField(h, "stage", fmt.Sprintf("%d", i))
+		binPath := stage.OptPath
+		args := stage.GetOptCLIArgs()
+		envVars := stage.OptEnvVars
+		if stage.Kind() == config.StageKind_Command {
+			binPath, err = exec.LookPath(stage.Command[0])
+			if err != nil {
+				return "", errors.Wrapf(err, "while finding %s", stage.Command[0])
+			}
+			args = stage.Command[1:]
+			envVars = stage.EnvVars
+		}
+		binHash, err := HashFile(binPath)
+		if err != nil {
+			return "", errors.Wrapf(err, "while hashing %s", binPath)
+		}
+		writeField(h, "bin", binHash)
+		for _, inputPath := range getStageInputs(&stage, args) {
+			inputHash, err := HashFile(inputPath)
+			if err != nil {
+				return "", errors.Wrapf(err, "while hashing %s", inputPath)
+			}
+			writeField(h, "input", inputHash)
+		}
+		for _, arg := range args {
+			writeField(h, "arg", arg)
+		}
+		writeEnvVars(h, "env", envVars)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// pluginArgNames are the args of opt (and clang) that load a plugin
+var pluginArgNames = []string{"-load-pass-plugin", "-load", "-fpass-plugin"}
+
+// getStageInputs returns the files, besides its input bitcode, that 'stage'
+// reads when it runs with 'args':
+//   - Its Inputs
+//   - For opt stages, the plugins loaded by 'args' (e.g.,
+//     "-load-pass-plugin=/path/to/MyPlugin.so" or "-load /path/to/Legacy.so")
+//   - For command stages, every arg that is an existing file (e.g., the script
+//     of "python3 /path/to/rewriter.py"), including the value of a
+//     "--flag=/path/to/file" arg
+func getStageInputs(stage *config.Stage, args []string) []string {
+	inputs := append([]string(nil), stage.Inputs...)
+	if stage.Kind() == config.StageKind_Command {
+		for _, arg := range args {
+			candidates := []string{arg}
+			if i := strings.IndexByte(arg, '='); i >= 0 {
+				candidates = append(candidates, arg[i+1:])
+			}
+			for _, candidate := range candidates {
+				info, err := os.Stat(candidate)
+				if err == nil && info.Mode().IsRegular() {
+					inputs = append(inputs, candidate)
+				}
+			}
+		}
+		return inputs
+	}
+	for i, arg := range args {
+		for _, name := range pluginArgNames {
+			switch {
+			case strings.HasPrefix(arg, name+"="):
+				inputs = append(inputs, strings.TrimPrefix(arg, name+"="))
+			case arg == name && i+1 < len(args):
+				inputs = append(inputs, args[i+1])
+			}
+		}
+	}
+	return inputs
+}
+
+// writeEnvVars writes every env var in 'envVars' as a field called 'name'
+// to 'w', sorted so that their order doesn't matter
+func writeEnvVars(w io.Writer, name string, envVars map[string]string) {
+	keys := make([]string, 0, len(envVars))
+	for k := range envVars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeField(w, name, k+"="+envVars[k])
+	}
+}
+
+// HashFile returns the hex-encoded sha256 of the file at 'path'
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeField writes a length-prefixed field to 'w' so that different
+// sequences of fields never produce the same bytes
+func writeField(w io.Writer, name string, value string) {
+	fmt.Fprintf(w, "%s:%d:%s\n", name, len(value), value)
+}
+
+func copyFile(srcPath string, dstPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
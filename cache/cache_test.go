@@ -0,0 +1,192 @@
+package cache
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/afjoseph/conjunct/config"
+	"github.com/afjoseph/conjunct/projectpath"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	logrus.SetLevel(logrus.DebugLevel)
+}
+
+func TestGetPut(t *testing.T) {
+	c, err := New(t.TempDir(), 0)
+	require.NoError(t, err)
+	tempDir := t.TempDir()
+	srcPath := filepath.Join(tempDir, "src.bc")
+	require.NoError(t, os.WriteFile(srcPath, []byte("hello"), 0644))
+	dstPath := filepath.Join(tempDir, "dst.bc")
+
+	// Miss
+	hit, err := c.Get("aaaa", dstPath)
+	require.NoError(t, err)
+	require.False(t, hit)
+
+	// Hit
+	require.NoError(t, c.Put("aaaa", srcPath))
+	hit, err = c.Get("aaaa", dstPath)
+	require.NoError(t, err)
+	require.True(t, hit)
+	b, err := os.ReadFile(dstPath)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+}
+
+func TestEvict(t *testing.T) {
+	cacheDir := t.TempDir()
+	// Only room for two 4-byte entries
+	c, err := New(cacheDir, 8)
+	require.NoError(t, err)
+	tempDir := t.TempDir()
+	srcPath := filepath.Join(tempDir, "src.bc")
+	require.NoError(t, os.WriteFile(srcPath, []byte("abcd"), 0644))
+	dstPath := filepath.Join(tempDir, "dst.bc")
+
+	require.NoError(t, c.Put("first", srcPath))
+	require.NoError(t, c.Put("second", srcPath))
+	// Make "first" the least recently used one
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(c.entryPath("first"), old, old))
+	require.NoError(t, c.Put("third", srcPath))
+
+	hit, err := c.Get("first", dstPath)
+	require.NoError(t, err)
+	require.False(t, hit)
+	for _, key := range []string{"second", "third"} {
+		hit, err := c.Get(key, dstPath)
+		require.NoError(t, err)
+		require.True(t, hit, key)
+	}
+}
+
+func TestKey(t *testing.T) {
+	optPath, err := exec.LookPath("opt")
+	require.NoError(t, err)
+	bitcodePath := filepath.Join(projectpath.Root, "testassets/unit/hello.bc")
+	stage := config.Stage{
+		OptPath:    optPath,
+		OptCLIArgs: []string{"--lowerswitch"},
+		OptEnvVars: map[string]string{"A": "1", "B": "2"},
+	}
+	baseKey, err := Key(bitcodePath, []config.Stage{stage}, nil)
+	require.NoError(t, err)
+
+	// Same inputs, same key. Env var order doesn't matter
+	sameStage := stage
+	sameStage.OptEnvVars = map[string]string{"B": "2", "A": "1"}
+	key, err := Key(bitcodePath, []config.Stage{sameStage}, nil)
+	require.NoError(t, err)
+	require.Equal(t, baseKey, key)
+
+	// Different args
+	otherStage := stage
+	otherStage.OptCLIArgs = []string{"--mem2reg"}
+	key, err = Key(bitcodePath, []config.Stage{otherStage}, nil)
+	require.NoError(t, err)
+	require.NotEqual(t, baseKey, key)
+
+	// Different env vars
+	otherStage = stage
+	otherStage.OptEnvVars = map[string]string{"A": "1"}
+	key, err = Key(bitcodePath, []config.Stage{otherStage}, nil)
+	require.NoError(t, err)
+	require.NotEqual(t, baseKey, key)
+
+	// Different bitcode
+	otherBitcodePath := filepath.Join(t.TempDir(), "other.bc")
+	require.NoError(t, os.WriteFile(otherBitcodePath, []byte("BC"), 0644))
+	key, err = Key(otherBitcodePath, []config.Stage{stage}, nil)
+	require.NoError(t, err)
+	require.NotEqual(t, baseKey, key)
+
+	// More stages
+	key, err = Key(bitcodePath, []config.Stage{stage, stage}, nil)
+	require.NoError(t, err)
+	require.NotEqual(t, baseKey, key)
+
+	// Different common env vars (e.g., the seed)
+	key, err = Key(
+		bitcodePath,
+		[]config.Stage{stage},
+		map[string]string{"CONJUNCT_SEED": "1"},
+	)
+	require.NoError(t, err)
+	require.NotEqual(t, baseKey, key)
+}
+
+func TestKeyStageInputs(t *testing.T) {
+	optPath, err := exec.LookPath("opt")
+	require.NoError(t, err)
+	bitcodePath := filepath.Join(projectpath.Root, "testassets/unit/hello.bc")
+	dir := t.TempDir()
+	pluginPath := filepath.Join(dir, "MyPlugin.so")
+	scriptPath := filepath.Join(dir, "rewriter.sh")
+	inputPath := filepath.Join(dir, "rewriter.conf")
+
+	var testcases = []struct {
+		name         string
+		inputStage   config.Stage
+		inputChanged string
+	}{
+		{
+			name: "Plugin in opt-cli-args",
+			inputStage: config.Stage{
+				OptPath:    optPath,
+				OptCLIArgs: []string{"-load-pass-plugin=" + pluginPath, "-passes=my-pass"},
+			},
+			inputChanged: pluginPath,
+		},
+		{
+			name: "Legacy plugin in opt-cli-args",
+			inputStage: config.Stage{
+				OptPath:    optPath,
+				OptCLIArgs: []string{"-load", pluginPath, "-my-pass"},
+			},
+			inputChanged: pluginPath,
+		},
+		{
+			name: "Plugin in plugins",
+			inputStage: config.Stage{
+				OptPath: optPath,
+				Plugins: []string{pluginPath},
+			},
+			inputChanged: pluginPath,
+		},
+		{
+			name: "Script of a command stage",
+			inputStage: config.Stage{
+				Command: []string{"sh", scriptPath, "{input}", "{output}"},
+			},
+			inputChanged: scriptPath,
+		},
+		{
+			name: "Declared input",
+			inputStage: config.Stage{
+				Command: []string{"sh", scriptPath, "--config=" + inputPath},
+				Inputs:  []string{inputPath},
+			},
+			inputChanged: inputPath,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, path := range []string{pluginPath, scriptPath, inputPath} {
+				require.NoError(t, os.WriteFile(path, []byte("v1"), 0644))
+			}
+			baseKey, err := Key(bitcodePath, []config.Stage{tc.inputStage}, nil)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(tc.inputChanged, []byte("v2"), 0644))
+			key, err := Key(bitcodePath, []config.Stage{tc.inputStage}, nil)
+			require.NoError(t, err)
+			require.NotEqual(t, baseKey, key)
+		})
+	}
+}
@@ -2,9 +2,14 @@ package config
 
 import (
 	stderr "errors"
-	"os"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/afjoseph/conjunct/argsparser"
+	"github.com/afjoseph/conjunct/invocation"
 	"github.com/afjoseph/conjunct/util"
 	"github.com/go-playground/errors/v5"
 	"github.com/sirupsen/logrus"
@@ -13,12 +18,32 @@ import (
 
 var (
 	ErrParsingConfig = stderr.New("Failed to parse config")
+	// Matches a hex-encoded SHA-256
+	sha256Regex = regexp.MustCompile(`^[0-9a-f]{64}$`)
 )
 
 type Config struct {
 	// Seed is the seed used for random number generation. Useful for some
-	// passes
-	Seed int64 `yaml:"seed"`
+	// passes. Every translation unit gets its own seed derived from Seed,
+	// through the "{seed}" placeholder and $CONJUNCT_SEED. Seed_Random picks
+	// a new seed once per build session
+	Seed Seed `yaml:"seed"`
+	// SeedManifestPath is a file where the seed of the build session and the
+	// seed of every translation unit are recorded as JSON lines. Required if
+	// Seed is Seed_Random, since concurrent invocations share the seed of the
+	// session through it
+	SeedManifestPath string `yaml:"seed-manifest-path"`
+	// SeedReplayFrom is a seed manifest (see SeedManifestPath) of a previous
+	// build to take the seed of the session from, instead of Seed
+	SeedReplayFrom string `yaml:"seed-replay-from"`
+	// SeedSessionIdleTimeout ends a build session once its seed manifest
+	// hasn't been written for that long, unless $CONJUNCT_SESSION is set.
+	// Defaults to DefaultSeedSessionIdleTimeout
+	SeedSessionIdleTimeout time.Duration `yaml:"seed-session-idle-timeout"`
+	// SeedPathRoot is the dir that the paths seeds are derived from are
+	// relative to, so that moving the source tree doesn't change the seeds.
+	// Defaults to the dir of the config file
+	SeedPathRoot string `yaml:"seed-path-root"`
 	// ClangDirPath is the path to the Clang binary
 	ClangDirPath string `yaml:"clang-dir-path"`
 	// OptPath is the path to the Opt binary
@@ -27,9 +52,329 @@ type Config struct {
 	OptEnvVars map[string]string `yaml:"opt-env-vars"`
 	// OptCLIArgs is a list of arguments to pass to Opt
 	OptCLIArgs []string `yaml:"opt-cli-args"`
+	// Plugins are the pass plugins to load in Opt. See Stage.Plugins
+	Plugins []string `yaml:"plugins"`
+	// Passes is the pipeline to run with Opt. See Stage.Passes
+	Passes Passes `yaml:"passes"`
+	// Stages is an ordered list of opt invocations. The output bitcode of
+	// each stage is fed to the next one.
+	//
+	// OptPath, OptEnvVars, OptCLIArgs, Plugins and Passes are a shorthand for
+	// a single stage.
+	// If Stages is set, OptPath is only used as the default opt binary for
+	// stages that don't specify their own.
+	Stages []Stage `yaml:"stages"`
+	// Profiles are named opt settings that replace OptPath, OptEnvVars,
+	// OptCLIArgs and Stages when they're selected, either by
+	// --conjunct-profile or by the optimization level of the compiler
+	// invocation. See Profile
+	Profiles map[string]*Profile `yaml:"profiles"`
+	// Pipelines are named lists of stages that rules can pick instead of
+	// Stages
+	Pipelines map[string][]Stage `yaml:"pipelines"`
+	// Rules pick what to do with specific source files: either skip them or
+	// run a different pipeline on them. See Rule
+	Rules []Rule `yaml:"rules"`
+	// Cache configures the on-disk cache of transformed bitcode
+	Cache CacheConfig `yaml:"cache"`
+	// OnFailure is what to do when a step of the pipeline fails. Stages can
+	// override it. Defaults to FailurePolicy_Fail
+	OnFailure FailurePolicy `yaml:"on-failure"`
+	// FailureReportPath is a file where every fallback to the original clang
+	// is recorded as a JSON line. Nothing is recorded if it's empty
+	FailureReportPath string `yaml:"failure-report-path"`
+	// Limits are the resource limits of the emit, opt and build steps
+	Limits LimitsConfig `yaml:"limits"`
+	// If CheckBitcodeCompat is true, the producer and epoch of the emitted
+	// bitcode are checked against the version of every opt binary before
+	// running the stages
+	CheckBitcodeCompat bool `yaml:"check-bitcode-compat"`
+	// If VerifyStages is true, the output of every stage is checked with
+	// 'opt -passes=verify'. Command stages are verified with OptPath
+	VerifyStages bool `yaml:"verify-stages"`
+	// ToolChecksums pins the SHA-256 of tools (e.g., opt, clang or pass
+	// plugins) by path. Conjunct refuses to run if a pinned tool doesn't
+	// match its pin
+	ToolChecksums map[string]string `yaml:"tool-checksums"`
+	// ToolChecksumCachePath is a file where the checksums of the pinned tools
+	// are cached by path, mtime and size, so that every tool is only hashed
+	// once per build session. Defaults to a file in the user's cache dir
+	ToolChecksumCachePath string `yaml:"tool-checksum-cache-path"`
+	// PassCheckCachePath is a file where the results of checking passes (see
+	// CheckPasses()) are cached by the path, mtime and size of opt and the
+	// plugins, so that opt isn't run on every invocation. Defaults to a file
+	// in the user's cache dir
+	PassCheckCachePath string `yaml:"pass-check-cache-path"`
+	// EnabledInvocationKinds are the kinds of compiler invocations the
+	// pipeline runs on. Every other invocation runs the original clang.
+	// Defaults to invocation.Kind_CompileToObject
+	EnabledInvocationKinds []invocation.Kind `yaml:"enabled-invocation-kinds"`
+	// If PreserveDebugInfo is true, debug info flags (e.g., -g) are kept
+	// through the emit, opt and build steps instead of being stripped
+	PreserveDebugInfo bool `yaml:"preserve-debug-info"`
+	// Profile is the name of the selected profile in Profiles, if any. See
+	// SelectProfile()
+	Profile string `yaml:"-"`
 	// If RetainTempDir is true, don't delete the temporary directory
 	// conjunct creates. Useful for debugging.
 	RetainTempDir bool `yaml:"-"`
+	// If NoCache is true, don't read or write the cache even if it's
+	// configured
+	NoCache bool `yaml:"-"`
+	// If UseResponseFiles is true, the emit and build steps pass their args
+	// to clang through a response file. It's set if the original invocation
+	// used one
+	UseResponseFiles bool `yaml:"-"`
+}
+
+// DefaultSeedSessionIdleTimeout is the default of
+// Config.SeedSessionIdleTimeout
+const DefaultSeedSessionIdleTimeout = 10 * time.Minute
+
+// GetSeedSessionIdleTimeout returns cfg.SeedSessionIdleTimeout, or its
+// default
+func (cfg *Config) GetSeedSessionIdleTimeout() time.Duration {
+	if cfg.SeedSessionIdleTimeout <= 0 {
+		return DefaultSeedSessionIdleTimeout
+	}
+	return cfg.SeedSessionIdleTimeout
+}
+
+// Seed is the seed of a build. See Config.Seed
+type Seed int64
+
+// Seed_Random is "seed: random": a new seed is picked once per build session
+const Seed_Random Seed = math.MinInt64
+
+func (seed *Seed) UnmarshalYAML(node *yaml.Node) error {
+	if node.Value == "random" {
+		*seed = Seed_Random
+		return nil
+	}
+	var i int64
+	if err := node.Decode(&i); err != nil {
+		return errors.Newf("seed must be an integer or random, not %q", node.Value)
+	}
+	if Seed(i) == Seed_Random {
+		return errors.Newf("seed %d is reserved", i)
+	}
+	*seed = Seed(i)
+	return nil
+}
+
+// CacheConfig configures the on-disk cache of transformed bitcode. On a
+// cache hit, the stages are skipped.
+type CacheConfig struct {
+	// Dir is the directory of the cache. The cache is disabled if Dir is
+	// empty
+	Dir string `yaml:"dir"`
+	// MaxSizeMB is the maximum size of the cache in megabytes. Least recently
+	// used entries are evicted first. 0 means unbounded
+	MaxSizeMB int64 `yaml:"max-size-mb"`
+}
+
+// StageKind is the kind of tool a Stage runs
+type StageKind int
+
+const (
+	// StageKind_Opt runs opt with the stage's OptCLIArgs
+	StageKind_Opt StageKind = 1
+	// StageKind_Command runs the stage's Command template
+	StageKind_Command StageKind = 2
+)
+
+// Stage is a single step in the pipeline: either an opt invocation or an
+// arbitrary command
+type Stage struct {
+	// Name is an optional name for the stage, used in logs and temporary
+	// file names
+	Name string `yaml:"name"`
+	// OptPath is the path to the Opt binary for this stage
+	OptPath string `yaml:"opt-path"`
+	// OptEnvArgs is a list of environment variables to setup while running
+	// Opt. The stage var placeholders (e.g., "${SOURCE_FILE}") are replaced
+	// in their values
+	OptEnvVars map[string]string `yaml:"opt-env-vars"`
+	// OptCLIArgs is a list of arguments to pass to Opt. "{seed}" is replaced
+	// with the seed of the translation unit, and the stage var placeholders
+	// (e.g., "${SOURCE_FILE}") with their values
+	OptCLIArgs []string `yaml:"opt-cli-args"`
+	// Plugins are the pass plugins to load in Opt, passed as
+	// -load-pass-plugin before OptCLIArgs
+	Plugins []string `yaml:"plugins"`
+	// Passes is the new pass manager pipeline to run with Opt, passed as
+	// -passes before OptCLIArgs. Every pass name is checked against
+	// 'opt --print-passes' before the build (see CheckPasses())
+	Passes Passes `yaml:"passes"`
+	// Command is a command template to run instead of opt. The first element
+	// is the binary to run. These placeholders are replaced in every element:
+	//   - {input}: the bitcode file from the previous stage
+	//   - {output}: the bitcode file this stage must write
+	//   - {tempdir}: Conjunct's temporary directory
+	//   - {source}: the source file being compiled
+	//   - {seed}: the seed of the translation unit
+	//   - The stage var placeholders (e.g., "${SOURCE_FILE}")
+	Command []string `yaml:"command"`
+	// Inputs are files the stage reads besides its input bitcode (e.g., a
+	// config file of a pass plugin or a module imported by a script). Their
+	// content is part of the cache key. Plugins and the files in the args of
+	// a command stage are part of it anyway
+	Inputs []string `yaml:"inputs"`
+	// EnvVars is a list of environment variables to setup while running
+	// Command. The stage var placeholders are replaced in their values
+	EnvVars map[string]string `yaml:"env-vars"`
+	// OnFailure overrides Config.OnFailure for this stage
+	OnFailure FailurePolicy `yaml:"on-failure"`
+	// Limits overrides Config.Limits.Stage for this stage
+	Limits *Limits `yaml:"limits"`
+}
+
+// LimitsConfig holds the resource limits of every step of the pipeline
+type LimitsConfig struct {
+	// Emit are the limits of emitting bitcode
+	Emit Limits `yaml:"emit"`
+	// Stage are the default limits of every stage. Stages can override it
+	Stage Limits `yaml:"stage"`
+	// Build are the limits of building the bitcode into an object file
+	Build Limits `yaml:"build"`
+}
+
+// Limits are the resource limits of a single process. Zero values mean no
+// limit.
+type Limits struct {
+	// Timeout is the wall-clock timeout (e.g., "90s" or "5m"). The process
+	// and its whole process group are killed when it expires
+	Timeout time.Duration `yaml:"timeout"`
+	// CPUSeconds is the CPU time limit (RLIMIT_CPU). Only supported on Linux
+	CPUSeconds uint64 `yaml:"cpu-seconds"`
+	// MemoryMB is the address space limit (RLIMIT_AS) in megabytes. Only
+	// supported on Linux
+	MemoryMB uint64 `yaml:"memory-mb"`
+}
+
+// GetStageLimits returns the limits of 'stage', falling back to the default
+// stage limits
+func (cfg *Config) GetStageLimits(stage *Stage) Limits {
+	if stage.Limits != nil {
+		return *stage.Limits
+	}
+	return cfg.Limits.Stage
+}
+
+// FailurePolicy is what to do when a step of the pipeline fails
+type FailurePolicy string
+
+const (
+	// FailurePolicy_Fail fails the compilation
+	FailurePolicy_Fail FailurePolicy = "fail"
+	// FailurePolicy_Fallback compiles the source file with the original
+	// clang, without running any stage
+	FailurePolicy_Fallback FailurePolicy = "fallback"
+	// FailurePolicy_Warn skips the failed stage and continues the pipeline
+	// with the output of the previous stage. Emitting and building bitcode
+	// can't be skipped, so it behaves like FailurePolicy_Fallback for those
+	FailurePolicy_Warn FailurePolicy = "warn"
+)
+
+func (policy *FailurePolicy) UnmarshalYAML(node *yaml.Node) error {
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+	switch FailurePolicy(s) {
+	case FailurePolicy_Fail, FailurePolicy_Fallback, FailurePolicy_Warn:
+		*policy = FailurePolicy(s)
+		return nil
+	}
+	return errors.Newf(
+		"line %d: bad on-failure value %q: must be one of fail, fallback or warn",
+		node.Line,
+		s,
+	)
+}
+
+// GetFailurePolicy returns the failure policy of 'stage', falling back to
+// the global one. If 'stage' is nil, the global policy is returned.
+func (cfg *Config) GetFailurePolicy(stage *Stage) FailurePolicy {
+	if stage != nil && len(stage.OnFailure) != 0 {
+		return stage.OnFailure
+	}
+	if len(cfg.OnFailure) != 0 {
+		return cfg.OnFailure
+	}
+	return FailurePolicy_Fail
+}
+
+// IsInvocationKindEnabled returns true if the pipeline runs on compiler
+// invocations of 'kind'. See EnabledInvocationKinds
+func (cfg *Config) IsInvocationKindEnabled(kind invocation.Kind) bool {
+	if len(cfg.EnabledInvocationKinds) == 0 {
+		return kind == invocation.Kind_CompileToObject
+	}
+	for _, k := range cfg.EnabledInvocationKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Kind returns the kind of tool 'stage' runs
+func (stage *Stage) Kind() StageKind {
+	if len(stage.Command) != 0 {
+		return StageKind_Command
+	}
+	return StageKind_Opt
+}
+
+// GetOptCLIArgs returns the arguments to pass to Opt for 'stage': a
+// -load-pass-plugin for every plugin in Plugins, then -passes with Passes,
+// if any, then OptCLIArgs
+func (stage *Stage) GetOptCLIArgs() []string {
+	args := []string{}
+	for _, plugin := range stage.Plugins {
+		args = append(args, "-load-pass-plugin="+plugin)
+	}
+	if len(stage.Passes) != 0 {
+		args = append(args, "-passes="+stage.Passes.String())
+	}
+	return append(args, stage.OptCLIArgs...)
+}
+
+// hasOptFields returns true if 'stage' has any opt field other than
+// OptPath
+func (stage *Stage) hasOptFields() bool {
+	return len(stage.OptCLIArgs) != 0 ||
+		len(stage.OptEnvVars) != 0 ||
+		len(stage.Plugins) != 0 ||
+		len(stage.Passes) != 0
+}
+
+// GetStages returns the stages to run, in order. If a profile is selected,
+// its stages are returned instead. If no 'stages' are specified, the
+// single-opt fields are returned as one stage.
+func (cfg *Config) GetStages() []Stage {
+	if profile, ok := cfg.Profiles[cfg.Profile]; ok {
+		return profile.GetStages()
+	}
+	if len(cfg.Stages) != 0 {
+		return cfg.Stages
+	}
+	if len(cfg.OptPath) == 0 {
+		return nil
+	}
+	return []Stage{cfg.getShorthandStage()}
+}
+
+// getShorthandStage returns the single-opt fields of 'cfg' as one stage
+func (cfg *Config) getShorthandStage() Stage {
+	return Stage{
+		OptPath:    cfg.OptPath,
+		OptEnvVars: cfg.OptEnvVars,
+		OptCLIArgs: cfg.OptCLIArgs,
+		Plugins:    cfg.Plugins,
+		Passes:     cfg.Passes,
+	}
 }
 
 // ExtractConfigFromArgs extracts conjunct config from 'args' and returns
@@ -56,18 +401,50 @@ func ExtractConfigFromArgs(
 	}
 	args = argsparser.RemoveArg(args, "--conjunct-config-path", true)
 
-	// Read and parse
-	configFileContent, err := os.ReadFile(configFilePath)
+	config, err := LoadConfigFile(configFilePath)
+	if err != nil {
+		return args, nil, err
+	}
+
+	profileName := argsparser.GetArgVal(args, "--conjunct-profile")
+	args = argsparser.RemoveArg(args, "--conjunct-profile", true)
+	err = config.SelectProfile(profileName, args)
 	if err != nil {
-		return args, nil, errors.Wrapf(err, "failed to read YAML file")
+		return args, nil, err
+	}
+
+	if argsparser.HasArg(args, "--conjunct-retain-temp-dir") {
+		config.RetainTempDir = true
+		args = argsparser.RemoveArg(
+			args,
+			"--conjunct-retain-temp-dir",
+			false,
+		)
 	}
-	if len(configFileContent) == 0 {
-		return args, nil, errors.New("empty config file")
+
+	if argsparser.HasArg(args, "--conjunct-no-cache") {
+		config.NoCache = true
+		args = argsparser.RemoveArg(args, "--conjunct-no-cache", false)
 	}
+
+	logrus.Debugf("Parsed Conjunct config file successfully: %+v", config)
+	return args, config, nil
+}
+
+// LoadConfigFile reads, validates and expands the paths of the config file
+// at 'configFilePath', merged with the config files it extends (see
+// loadConfigNode()). Unknown keys are an error (see checkUnknownKeys())
+func LoadConfigFile(configFilePath string) (*Config, error) {
+	// Read, merge with the config files it extends and parse
+	root, err := loadConfigNode(configFilePath, []string{})
+	if err != nil {
+		return nil, err
+	}
+	clearListMarkers(root)
 	config := Config{}
-	err = yaml.Unmarshal(configFileContent, &config)
+	err = root.Decode(&config)
 	if err != nil {
-		return args, nil, errors.Wrapf(
+		return nil, errors.Wrapf(
 			ErrParsingConfig,
 			"at %s: %v",
 			configFilePath,
@@ -75,7 +452,49 @@ func ExtractConfigFromArgs(
 		)
 	}
 	if config.Seed == 0 {
-		return args, nil, errors.New("missing seed in config")
+		return nil, errors.New("missing seed in config")
+	}
+	if config.Seed == Seed_Random && len(config.SeedManifestPath) == 0 &&
+		len(config.SeedReplayFrom) == 0 {
+		return nil, errors.New("seed: random requires seed-manifest-path")
+	}
+	if len(config.SeedManifestPath) != 0 {
+		config.SeedManifestPath, err = util.ExpandPath(
+			config.SeedManifestPath,
+			false,
+		)
+		if err != nil {
+			return nil, errors.Wrapf(
+				err,
+				"failed to expand seed manifest path: %s",
+				config.SeedManifestPath,
+			)
+		}
+	}
+	if len(config.SeedReplayFrom) != 0 {
+		config.SeedReplayFrom, err = util.ExpandPath(
+			config.SeedReplayFrom,
+			false,
+		)
+		if err != nil {
+			return nil, errors.Wrapf(
+				err,
+				"failed to expand seed replay path: %s",
+				config.SeedReplayFrom,
+			)
+		}
+	}
+
+	if len(config.SeedPathRoot) == 0 {
+		config.SeedPathRoot = filepath.Dir(configFilePath)
+	}
+	config.SeedPathRoot, err = util.ExpandPath(config.SeedPathRoot, false)
+	if err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"failed to expand seed path root: %s",
+			config.SeedPathRoot,
+		)
 	}
 
 	// XXX <05-10-2023, afjoseph> Don't expand symlinks here. There **is** a
@@ -87,7 +506,7 @@ func ExtractConfigFromArgs(
 	// `clang`, which will cause libstd++ linking errors.
 	config.ClangDirPath, err = util.ExpandPath(config.ClangDirPath, false)
 	if err != nil {
-		return args, nil, errors.Wrapf(
+		return nil, errors.Wrapf(
 			err,
 			"failed to expand clang dir path: %s",
 			config.ClangDirPath,
@@ -96,23 +515,240 @@ func ExtractConfigFromArgs(
 
 	// XXX <06-10-2023, afjoseph> Don't expand symlinks here: this fails a few
 	// unit tests where symlinks are not expanded
-	config.OptPath, err = util.ExpandPath(config.OptPath, false)
+	//
+	// opt-path can be empty if every stage specifies its own
+	if len(config.OptPath) != 0 || len(config.Stages) == 0 {
+		config.OptPath, err = util.ExpandPath(config.OptPath, false)
+		if err != nil {
+			return nil, errors.Wrapf(
+				err,
+				"failed to expand opt path: %s",
+				config.OptPath,
+			)
+		}
+	}
+	shorthandStage := config.getShorthandStage()
+	if len(config.Stages) != 0 && shorthandStage.hasOptFields() {
+		return nil, errors.New(
+			"opt-cli-args, opt-env-vars, plugins and passes can't be used with stages",
+		)
+	}
+	err = expandPaths(config.Plugins, "plugin")
 	if err != nil {
-		return args, nil, errors.Wrapf(
-			err,
-			"failed to expand opt path: %s",
-			config.OptPath,
+		return nil, err
+	}
+	err = expandStages(config.Stages, config.OptPath)
+	if err != nil {
+		return nil, err
+	}
+	for name, stages := range config.Pipelines {
+		err = expandStages(stages, config.OptPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "in pipeline %s", name)
+		}
+	}
+	for name, profile := range config.Profiles {
+		err = profile.expand(config.OptPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "in profile %s", name)
+		}
+	}
+	err = validateProfiles(config.Profiles)
+	if err != nil {
+		return nil, err
+	}
+	if config.VerifyStages && len(config.OptPath) == 0 &&
+		hasCommandStage(&config) {
+		return nil, errors.New(
+			"verify-stages with command stages requires opt-path",
 		)
 	}
-	if argsparser.HasArg(args, "--conjunct-retain-temp-dir") {
-		config.RetainTempDir = true
-		args = argsparser.RemoveArg(
-			args,
-			"--conjunct-retain-temp-dir",
+	for _, kind := range config.EnabledInvocationKinds {
+		if !kind.CanRunPipeline() {
+			return nil, errors.Newf(
+				"the pipeline can't run on %s invocations",
+				kind,
+			)
+		}
+	}
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		if err := rule.compile(); err != nil {
+			return nil, errors.Wrapf(err, "in rule #%d", i)
+		}
+		if len(rule.Pipeline) == 0 {
+			continue
+		}
+		if _, ok := config.Pipelines[rule.Pipeline]; !ok {
+			return nil, errors.Newf(
+				"rule #%d: unknown pipeline %s",
+				i,
+				rule.Pipeline,
+			)
+		}
+	}
+	if len(config.Cache.Dir) != 0 {
+		config.Cache.Dir, err = util.ExpandPath(config.Cache.Dir, false)
+		if err != nil {
+			return nil, errors.Wrapf(
+				err,
+				"failed to expand cache dir: %s",
+				config.Cache.Dir,
+			)
+		}
+	}
+	config.ToolChecksums, err = expandToolChecksums(config.ToolChecksums)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.PassCheckCachePath) != 0 {
+		config.PassCheckCachePath, err = util.ExpandPath(
+			config.PassCheckCachePath,
+			false,
+		)
+		if err != nil {
+			return nil, errors.Wrapf(
+				err,
+				"failed to expand pass check cache path: %s",
+				config.PassCheckCachePath,
+			)
+		}
+	}
+	if len(config.ToolChecksumCachePath) != 0 {
+		config.ToolChecksumCachePath, err = util.ExpandPath(
+			config.ToolChecksumCachePath,
 			false,
 		)
+		if err != nil {
+			return nil, errors.Wrapf(
+				err,
+				"failed to expand tool checksum cache path: %s",
+				config.ToolChecksumCachePath,
+			)
+		}
 	}
+	if len(config.FailureReportPath) != 0 {
+		config.FailureReportPath, err = util.ExpandPath(
+			config.FailureReportPath,
+			false,
+		)
+		if err != nil {
+			return nil, errors.Wrapf(
+				err,
+				"failed to expand failure report path: %s",
+				config.FailureReportPath,
+			)
+		}
+	}
+	return &config, nil
+}
 
-	logrus.Debugf("Parsed Conjunct config file successfully: %+v", config)
-	return args, &config, nil
+// hasCommandStage returns true if the stages of 'config', or any of its
+// pipelines or profiles, have a command stage
+func hasCommandStage(config *Config) bool {
+	allStages := append([]Stage(nil), config.Stages...)
+	for _, pipelineStages := range config.Pipelines {
+		allStages = append(allStages, pipelineStages...)
+	}
+	for _, profile := range config.Profiles {
+		allStages = append(allStages, profile.Stages...)
+	}
+	for _, stage := range allStages {
+		if stage.Kind() == StageKind_Command {
+			return true
+		}
+	}
+	return false
+}
+
+// expandStages validates every stage in 'stages' and expands their paths in
+// place. Opt stages without an opt-path use 'defaultOptPath'.
+func expandStages(stages []Stage, defaultOptPath string) (err error) {
+	for i := range stages {
+		stage := &stages[i]
+		err = expandPaths(stage.Inputs, "input")
+		if err != nil {
+			return errors.Wrapf(err, "in stage #%d", i)
+		}
+		if stage.Kind() == StageKind_Command {
+			if len(stage.OptPath) != 0 || stage.hasOptFields() {
+				return errors.Newf(
+					"stage #%d: command can't be used with opt fields",
+					i,
+				)
+			}
+			// Only expand paths: bare binary names are looked up in $PATH
+			if strings.ContainsRune(stage.Command[0], filepath.Separator) {
+				stage.Command[0], err = util.ExpandPath(stage.Command[0], false)
+				if err != nil {
+					return errors.Wrapf(
+						err,
+						"failed to expand command path of stage #%d: %s",
+						i,
+						stage.Command[0],
+					)
+				}
+			}
+			continue
+		}
+		if len(stage.OptPath) == 0 {
+			stage.OptPath = defaultOptPath
+		}
+		if len(stage.OptPath) == 0 {
+			return errors.Newf("missing opt-path in stage #%d", i)
+		}
+		stage.OptPath, err = util.ExpandPath(stage.OptPath, false)
+		if err != nil {
+			return errors.Wrapf(
+				err,
+				"failed to expand opt path of stage #%d: %s",
+				i,
+				stage.OptPath,
+			)
+		}
+		err = expandPaths(stage.Plugins, "plugin")
+		if err != nil {
+			return errors.Wrapf(err, "in stage #%d", i)
+		}
+	}
+	return nil
+}
+
+// expandToolChecksums returns 'checksums' with their paths expanded and
+// their checksums lowercased. Every checksum must be a hex-encoded SHA-256
+func expandToolChecksums(
+	checksums map[string]string,
+) (map[string]string, error) {
+	if len(checksums) == 0 {
+		return nil, nil
+	}
+	ret := map[string]string{}
+	for path, checksum := range checksums {
+		checksum = strings.ToLower(checksum)
+		if !sha256Regex.MatchString(checksum) {
+			return nil, errors.Newf(
+				"tool checksum of %s must be a hex-encoded SHA-256, not %q",
+				path,
+				checksum,
+			)
+		}
+		expandedPath, err := util.ExpandPath(path, false)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to expand tool path: %s", path)
+		}
+		ret[expandedPath] = checksum
+	}
+	return ret, nil
+}
+
+// expandPaths expands every path in 'paths' in place. 'what' says what the
+// paths are (e.g., "plugin")
+func expandPaths(paths []string, what string) (err error) {
+	for i, path := range paths {
+		paths[i], err = util.ExpandPath(path, false)
+		if err != nil {
+			return errors.Wrapf(err, "failed to expand %s path: %s", what, path)
+		}
+	}
+	return nil
 }
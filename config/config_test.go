@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/afjoseph/conjunct/invocation"
 	"github.com/afjoseph/conjunct/projectpath"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
@@ -20,7 +21,8 @@ func TestExtractConfig(t *testing.T) {
 	// paths to find clang and opt on your development machine
 	clangPath, err := exec.LookPath("clang")
 	require.NoError(t, err)
-	os.Setenv("CLANG_DIR_PATH", filepath.Dir(clangPath))
+	clangDirPath := filepath.Dir(clangPath)
+	os.Setenv("CLANG_DIR_PATH", clangDirPath)
 	optPath, err := exec.LookPath("opt")
 	require.NoError(t, err)
 	os.Setenv("OPT_PATH", optPath)
@@ -43,7 +45,8 @@ func TestExtractConfig(t *testing.T) {
 				"whatever.c"},
 			expectedConfig: &Config{
 				Seed:         123456789,
-				ClangDirPath: clangPath,
+				SeedPathRoot: filepath.Join(projectpath.Root, "testassets/unit"),
+				ClangDirPath: clangDirPath,
 				OptPath:      optPath,
 				OptCLIArgs:   []string{"--lowerswitch"},
 			},
@@ -58,7 +61,63 @@ func TestExtractConfig(t *testing.T) {
 					"example_config_1.yaml")},
 			expectedConfig: &Config{
 				Seed:         123456789,
-				ClangDirPath: clangPath,
+				SeedPathRoot: filepath.Join(projectpath.Root, "testassets/unit"),
+				ClangDirPath: clangDirPath,
+				OptPath:      optPath,
+				OptCLIArgs:   []string{"--lowerswitch"},
+			},
+		},
+		{
+			name: "Good #3: multiple stages",
+			inputArgs: []string{
+				"--conjunct-config-path",
+				filepath.Join(
+					projectpath.Root,
+					"testassets/unit",
+					"example_config_2.yaml"),
+				"-c",
+				"whatever.c"},
+			expectedConfig: &Config{
+				Seed:         123456789,
+				SeedPathRoot: filepath.Join(projectpath.Root, "testassets/unit"),
+				ClangDirPath: clangDirPath,
+				OptPath:      optPath,
+				Stages: []Stage{
+					{
+						Name:       "lowerswitch",
+						OptPath:    optPath,
+						OptCLIArgs: []string{"--lowerswitch"},
+					},
+					{
+						Name:       "mem2reg",
+						OptPath:    optPath,
+						OptEnvVars: map[string]string{"FOO": "bar"},
+						OptCLIArgs: []string{"--mem2reg"},
+					},
+					{
+						Name: "roundtrip",
+						Command: []string{
+							"sh",
+							"-c",
+							"llvm-dis {input} -o - | llvm-as -o {output}",
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Good #4: joined config path",
+			inputArgs: []string{
+				"--conjunct-config-path=" + filepath.Join(
+					projectpath.Root,
+					"testassets/unit",
+					"example_config_1.yaml"),
+				"-c",
+				"whatever.c"},
+			expectedConfig: &Config{
+				Seed:         123456789,
+				SeedPathRoot: filepath.Join(projectpath.Root, "testassets/unit"),
+				ClangDirPath: clangDirPath,
 				OptPath:      optPath,
 				OptCLIArgs:   []string{"--lowerswitch"},
 			},
@@ -94,7 +153,242 @@ func TestExtractConfig(t *testing.T) {
 				)
 			}
 			require.Equal(t, tc.expectedConfig, actualConfig)
-			require.NotContains(t, actualArgs, "-conjunct-config-path")
+			for _, arg := range actualArgs {
+				require.NotContains(t, arg, "--conjunct-config-path")
+			}
+		})
+	}
+}
+
+func TestGetStages(t *testing.T) {
+	var testcases = []struct {
+		name           string
+		inputConfig    *Config
+		expectedStages []Stage
+	}{
+		{
+			name: "Single-opt shorthand",
+			inputConfig: &Config{
+				OptPath:    "/opt",
+				OptCLIArgs: []string{"--lowerswitch"},
+			},
+			expectedStages: []Stage{
+				{OptPath: "/opt", OptCLIArgs: []string{"--lowerswitch"}},
+			},
+		},
+		{
+			name: "Stages take precedence",
+			inputConfig: &Config{
+				OptPath: "/opt",
+				Stages: []Stage{
+					{Name: "a", OptPath: "/opt"},
+					{Name: "b", OptPath: "/other-opt"},
+				},
+			},
+			expectedStages: []Stage{
+				{Name: "a", OptPath: "/opt"},
+				{Name: "b", OptPath: "/other-opt"},
+			},
+		},
+		{
+			name:           "No opt",
+			inputConfig:    &Config{},
+			expectedStages: nil,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expectedStages, tc.inputConfig.GetStages())
+		})
+	}
+}
+
+func TestIsInvocationKindEnabled(t *testing.T) {
+	var testcases = []struct {
+		name           string
+		inputConfig    *Config
+		inputKind      invocation.Kind
+		expectedRetval bool
+	}{
+		{
+			name:           "Object compilation is enabled by default",
+			inputConfig:    &Config{},
+			inputKind:      invocation.Kind_CompileToObject,
+			expectedRetval: true,
+		},
+		{
+			name:           "Assembly compilation is disabled by default",
+			inputConfig:    &Config{},
+			inputKind:      invocation.Kind_CompileToAssembly,
+			expectedRetval: false,
+		},
+		{
+			name: "Enabled kinds replace the default",
+			inputConfig: &Config{
+				EnabledInvocationKinds: []invocation.Kind{
+					invocation.Kind_CompileToAssembly,
+				},
+			},
+			inputKind:      invocation.Kind_CompileToObject,
+			expectedRetval: false,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(
+				t,
+				tc.expectedRetval,
+				tc.inputConfig.IsInvocationKindEnabled(tc.inputKind),
+			)
+		})
+	}
+}
+
+func TestExtractConfigRejectsInvocationKinds(t *testing.T) {
+	configFilePath := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(
+		configFilePath,
+		[]byte(`seed: 1
+clang-dir-path: /
+opt-path: /
+enabled-invocation-kinds: [compile-to-object, link]
+`),
+		0644,
+	)
+	require.NoError(t, err)
+	_, cfg, err := ExtractConfigFromArgs(
+		[]string{"--conjunct-config-path", configFilePath, "-c", "whatever.c"},
+	)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "can't run on link invocations")
+	require.Nil(t, cfg)
+}
+
+func TestExtractConfigRejectsUnknownKeys(t *testing.T) {
+	configFilePath := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(
+		configFilePath,
+		[]byte(`seed: 1
+clang-dir-path: /
+opt-path: /
+opt-cli-arg: [--lowerswitch]
+stages:
+  - name: a
+    opt-pth: /
+cache:
+  dir: /tmp
+`),
+		0644,
+	)
+	require.NoError(t, err)
+	_, cfg, err := ExtractConfigFromArgs(
+		[]string{"--conjunct-config-path", configFilePath, "-c", "whatever.c"},
+	)
+	require.ErrorIs(t, err, ErrParsingConfig)
+	require.Contains(t, err.Error(), `line 4, column 1: unknown key "opt-cli-arg"`)
+	require.Contains(t, err.Error(), `line 7, column 5: unknown key "opt-pth"`)
+	require.Nil(t, cfg)
+}
+
+func TestExtractConfigSeed(t *testing.T) {
+	var testcases = []struct {
+		name         string
+		inputSeed    string
+		expectedSeed Seed
+		// expectedSeedPathRoot defaults to the dir of the config file
+		expectedSeedPathRoot string
+		expectedError        string
+	}{
+		{
+			name:         "Integer",
+			inputSeed:    "seed: 42",
+			expectedSeed: 42,
+		},
+		{
+			name:                 "Seed path root",
+			inputSeed:            "seed: 42\nseed-path-root: /tmp/../srv/",
+			expectedSeed:         42,
+			expectedSeedPathRoot: "/srv",
+		},
+		{
+			name:         "Random",
+			inputSeed:    "seed: random\nseed-manifest-path: /tmp/seeds.jsonl",
+			expectedSeed: Seed_Random,
+		},
+		{
+			name:          "Random without a manifest",
+			inputSeed:     "seed: random",
+			expectedError: "seed: random requires seed-manifest-path",
+		},
+		{
+			name:          "Neither an integer nor random",
+			inputSeed:     "seed: often",
+			expectedError: `seed must be an integer or random, not "often"`,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			configFilePath := filepath.Join(t.TempDir(), "config.yaml")
+			err := os.WriteFile(
+				configFilePath,
+				[]byte(tc.inputSeed+"\nclang-dir-path: /\nopt-path: /\n"),
+				0644,
+			)
+			require.NoError(t, err)
+			cfg, err := LoadConfigFile(configFilePath)
+			if len(tc.expectedError) != 0 {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedSeed, cfg.Seed)
+			expectedSeedPathRoot := tc.expectedSeedPathRoot
+			if len(expectedSeedPathRoot) == 0 {
+				expectedSeedPathRoot = filepath.Dir(configFilePath)
+			}
+			require.Equal(t, expectedSeedPathRoot, cfg.SeedPathRoot)
+		})
+	}
+}
+
+func TestExtractConfigToolChecksums(t *testing.T) {
+	checksum := "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+	var testcases = []struct {
+		name              string
+		inputChecksums    string
+		expectedChecksums map[string]string
+		expectedError     string
+	}{
+		{
+			name: "Paths are expanded and checksums lowercased",
+			inputChecksums: "tool-checksums:\n" +
+				"  /usr/bin/../bin/opt: 5891B5B522D5DF086D0FF0B110FBD9D21BB4FC7163AF34D08286A2E846F6BE03\n",
+			expectedChecksums: map[string]string{"/usr/bin/opt": checksum},
+		},
+		{
+			name:           "Not a SHA-256",
+			inputChecksums: "tool-checksums:\n  /usr/bin/opt: abc123\n",
+			expectedError:  `tool checksum of /usr/bin/opt must be a hex-encoded SHA-256, not "abc123"`,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			configFilePath := filepath.Join(t.TempDir(), "config.yaml")
+			err := os.WriteFile(
+				configFilePath,
+				[]byte("seed: 1\nclang-dir-path: /\nopt-path: /\n"+tc.inputChecksums),
+				0644,
+			)
+			require.NoError(t, err)
+			cfg, err := LoadConfigFile(configFilePath)
+			if len(tc.expectedError) != 0 {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedChecksums, cfg.ToolChecksums)
 		})
 	}
 }
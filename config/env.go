@@ -0,0 +1,129 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/afjoseph/conjunct/argsparser"
+	"github.com/afjoseph/conjunct/sourcefile"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ConfigEnvVar is the environment variable with the path of the config
+	// file, for build systems that can't pass --conjunct-config-path
+	ConfigEnvVar = "CONJUNCT_CONFIG"
+	// FlagsEnvVar is the environment variable with Conjunct flags (e.g.,
+	// "--conjunct-verbose --conjunct-no-cache"), for build systems that can't
+	// pass them
+	FlagsEnvVar = "CONJUNCT_FLAGS"
+	// DiscoveredConfigFileName is the name of the config file Conjunct looks
+	// for in the directory of the source file and its parents
+	DiscoveredConfigFileName = ".conjunct.yaml"
+)
+
+// ArgOrigins maps the name of every Conjunct flag (e.g.,
+// "--conjunct-config-path") to where it came from. See ResolveConjunctArgs()
+type ArgOrigins map[string]string
+
+// Log logs where every Conjunct flag came from in verbose mode
+func (origins ArgOrigins) Log() {
+	names := []string{}
+	for name := range origins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		logrus.Debugf("Using %s from %s", name, origins[name])
+	}
+}
+
+// ResolveConjunctArgs adds to 'args' the Conjunct flags that come from the
+// environment instead of the command line. In order of precedence:
+//   - Flags in 'args' (i.e., the command line)
+//   - Flags in $CONJUNCT_FLAGS that aren't in 'args'. Arguments that aren't
+//     Conjunct flags are ignored with a warning
+//   - If there's still no --conjunct-config-path, $CONJUNCT_CONFIG
+//   - If there's still no --conjunct-config-path, the first
+//     '.conjunct.yaml' file in the directory of the source file or one of its
+//     parents
+//
+// Returns the new args and where every Conjunct flag in them came from
+func ResolveConjunctArgs(args []string) (retArgs []string, origins ArgOrigins) {
+	origins = ArgOrigins{}
+	for _, parsedArg := range argsparser.Parse(args) {
+		if isConjunctArg(parsedArg) {
+			origins[parsedArg.Option.Name] = "the command line"
+		}
+	}
+
+	envArgs := argsparser.TokenizeGNU(os.Getenv(FlagsEnvVar))
+	for _, parsedArg := range argsparser.Parse(envArgs) {
+		rawArgs := envArgs[parsedArg.Index : parsedArg.Index+parsedArg.Count]
+		if !isConjunctArg(parsedArg) {
+			logrus.Warnf(
+				"Ignoring %s in $%s: only Conjunct flags are allowed",
+				strings.Join(rawArgs, " "),
+				FlagsEnvVar,
+			)
+			continue
+		}
+		if _, ok := origins[parsedArg.Option.Name]; ok {
+			// The command line wins
+			continue
+		}
+		args = append(args, rawArgs...)
+		origins[parsedArg.Option.Name] = "$" + FlagsEnvVar
+	}
+	if _, ok := origins["--conjunct-config-path"]; ok {
+		return args, origins
+	}
+
+	configFilePath := os.Getenv(ConfigEnvVar)
+	origin := "$" + ConfigEnvVar
+	if len(configFilePath) == 0 {
+		configFilePath = discoverConfigFile(args)
+		origin = "discovered " + configFilePath
+	}
+	if len(configFilePath) == 0 {
+		return args, origins
+	}
+	args = append(args, "--conjunct-config-path", configFilePath)
+	origins["--conjunct-config-path"] = origin
+	return args, origins
+}
+
+// isConjunctArg returns true if 'parsedArg' is a known Conjunct flag
+func isConjunctArg(parsedArg argsparser.Arg) bool {
+	return parsedArg.Option != nil &&
+		strings.HasPrefix(parsedArg.Option.Name, "--conjunct-")
+}
+
+// discoverConfigFile returns the path of the first '.conjunct.yaml' file in
+// the directory of the source file of 'args' or one of its parents, or an
+// empty string if there's none
+func discoverConfigFile(args []string) string {
+	sourceFilepath, _ := sourcefile.GetSourceFilePath(args)
+	if len(sourceFilepath) == 0 {
+		return ""
+	}
+	dir, err := filepath.Abs(filepath.Dir(sourceFilepath))
+	if err != nil {
+		logrus.Debugf("Failed to resolve directory of %s: %v", sourceFilepath, err)
+		return ""
+	}
+	for {
+		configFilePath := filepath.Join(dir, DiscoveredConfigFileName)
+		info, err := os.Stat(configFilePath)
+		if err == nil && !info.IsDir() {
+			return configFilePath
+		}
+		parentDir := filepath.Dir(dir)
+		if parentDir == dir {
+			return ""
+		}
+		dir = parentDir
+	}
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveConjunctArgs(t *testing.T) {
+	// Discovery finds the config file in a parent of the source file's
+	// directory
+	rootDir := t.TempDir()
+	discoveredConfigPath := filepath.Join(rootDir, DiscoveredConfigFileName)
+	err := os.WriteFile(discoveredConfigPath, []byte("seed: 1\n"), 0644)
+	require.NoError(t, err)
+	sourceDir := filepath.Join(rootDir, "src", "lib")
+	require.NoError(t, os.MkdirAll(sourceDir, 0755))
+	sourcePath := filepath.Join(sourceDir, "foo.c")
+	otherSourcePath := filepath.Join(t.TempDir(), "foo.c")
+
+	var testcases = []struct {
+		name            string
+		inputArgs       []string
+		inputFlagsEnv   string
+		inputConfigEnv  string
+		expectedArgs    []string
+		expectedOrigins ArgOrigins
+	}{
+		{
+			name:      "Command line only",
+			inputArgs: []string{"--conjunct-config-path", "a.yaml", "-c", otherSourcePath},
+			expectedArgs: []string{
+				"--conjunct-config-path", "a.yaml", "-c", otherSourcePath,
+			},
+			expectedOrigins: ArgOrigins{
+				"--conjunct-config-path": "the command line",
+			},
+		},
+		{
+			name:          "Flags from the environment",
+			inputArgs:     []string{"-c", otherSourcePath},
+			inputFlagsEnv: "--conjunct-verbose --conjunct-config-path 'b c.yaml' -O2",
+			expectedArgs: []string{
+				"-c", otherSourcePath,
+				"--conjunct-verbose",
+				"--conjunct-config-path", "b c.yaml",
+			},
+			expectedOrigins: ArgOrigins{
+				"--conjunct-verbose":     "$CONJUNCT_FLAGS",
+				"--conjunct-config-path": "$CONJUNCT_FLAGS",
+			},
+		},
+		{
+			name:           "Command line wins over the environment",
+			inputArgs:      []string{"--conjunct-config-path=a.yaml", "-c", sourcePath},
+			inputFlagsEnv:  "--conjunct-config-path b.yaml --conjunct-no-cache",
+			inputConfigEnv: "c.yaml",
+			expectedArgs: []string{
+				"--conjunct-config-path=a.yaml", "-c", sourcePath,
+				"--conjunct-no-cache",
+			},
+			expectedOrigins: ArgOrigins{
+				"--conjunct-config-path": "the command line",
+				"--conjunct-no-cache":    "$CONJUNCT_FLAGS",
+			},
+		},
+		{
+			name:           "Config from the environment wins over discovery",
+			inputArgs:      []string{"-c", sourcePath},
+			inputConfigEnv: "c.yaml",
+			expectedArgs: []string{
+				"-c", sourcePath, "--conjunct-config-path", "c.yaml",
+			},
+			expectedOrigins: ArgOrigins{
+				"--conjunct-config-path": "$CONJUNCT_CONFIG",
+			},
+		},
+		{
+			name:      "Discovered config",
+			inputArgs: []string{"-c", sourcePath},
+			expectedArgs: []string{
+				"-c", sourcePath, "--conjunct-config-path", discoveredConfigPath,
+			},
+			expectedOrigins: ArgOrigins{
+				"--conjunct-config-path": "discovered " + discoveredConfigPath,
+			},
+		},
+		{
+			name:            "Nothing to discover",
+			inputArgs:       []string{"-c", otherSourcePath},
+			expectedArgs:    []string{"-c", otherSourcePath},
+			expectedOrigins: ArgOrigins{},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(FlagsEnvVar, tc.inputFlagsEnv)
+			t.Setenv(ConfigEnvVar, tc.inputConfigEnv)
+			actualArgs, actualOrigins := ResolveConjunctArgs(tc.inputArgs)
+			require.Equal(t, tc.expectedArgs, actualArgs)
+			require.Equal(t, tc.expectedOrigins, actualOrigins)
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/go-playground/errors/v5"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// extendsKey is the key of the config file a config file extends
+	extendsKey = "extends"
+	// appendTag marks a list that is appended to the list of the extended
+	// config file instead of replacing it (e.g., "opt-cli-args: !append
+	// [--mem2reg]")
+	appendTag = "!append"
+	// replaceTag marks a list that replaces the list of the extended config
+	// file. It's the default, so it's only there to be explicit
+	replaceTag = "!replace"
+)
+
+// loadConfigNode reads and parses the config file at 'configFilePath' and
+// merges it onto the config file it extends, if any (see
+// mergeConfigNodes()). Paths in "extends" are relative to the directory of
+// the config file.
+//
+// Every config file is checked for unknown keys on its own so that errors
+// point to the right file. 'stack' is the absolute paths of the config files
+// being loaded, to catch cycles
+func loadConfigNode(configFilePath string, stack []string) (*yaml.Node, error) {
+	absPath, err := filepath.Abs(configFilePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while resolving %s", configFilePath)
+	}
+	for _, p := range stack {
+		if p == absPath {
+			return nil, errors.Newf("config file %s extends itself", configFilePath)
+		}
+	}
+	configFileContent, err := os.ReadFile(configFilePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read YAML file")
+	}
+	if len(configFileContent) == 0 {
+		return nil, errors.Newf("empty config file %s", configFilePath)
+	}
+	doc := yaml.Node{}
+	err = yaml.Unmarshal(configFileContent, &doc)
+	if err != nil {
+		return nil, errors.Wrapf(ErrParsingConfig, "at %s: %v", configFilePath, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, errors.Newf("empty config file %s", configFilePath)
+	}
+	root := doc.Content[0]
+	extendsPath, err := popExtends(root)
+	if err != nil {
+		return nil, errors.Wrapf(ErrParsingConfig, "at %s: %v", configFilePath, err)
+	}
+	err = checkUnknownKeys(root)
+	if err != nil {
+		return nil, errors.Wrapf(ErrParsingConfig, "at %s: %v", configFilePath, err)
+	}
+	if len(extendsPath) == 0 {
+		return root, nil
+	}
+
+	if !filepath.IsAbs(extendsPath) {
+		extendsPath = filepath.Join(filepath.Dir(absPath), extendsPath)
+	}
+	parent, err := loadConfigNode(extendsPath, append(stack, absPath))
+	if err != nil {
+		return nil, errors.Wrapf(err, "in %s, extended by %s", extendsPath, configFilePath)
+	}
+	return mergeConfigNodes(parent, root), nil
+}
+
+// popExtends removes the "extends" key from 'root', the top-level node of a
+// config file, and returns its value
+func popExtends(root *yaml.Node) (string, error) {
+	if root.Kind != yaml.MappingNode {
+		return "", nil
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, val := root.Content[i], root.Content[i+1]
+		if key.Value != extendsKey {
+			continue
+		}
+		if val.Kind != yaml.ScalarNode || len(val.Value) == 0 {
+			return "", errors.Newf(
+				"line %d, column %d: extends must be a path",
+				val.Line,
+				val.Column,
+			)
+		}
+		root.Content = append(root.Content[:i:i], root.Content[i+2:]...)
+		return val.Value, nil
+	}
+	return "", nil
+}
+
+// mergeConfigNodes returns 'child' merged onto 'parent':
+//   - Maps (e.g., opt-env-vars) are merged key by key, recursively
+//   - Lists (e.g., opt-cli-args) in 'child' replace the ones in 'parent',
+//     unless they're tagged with "!append"
+//   - Everything else in 'child' replaces what's in 'parent'
+//
+// Neither 'parent' nor 'child' are modified
+func mergeConfigNodes(parent *yaml.Node, child *yaml.Node) *yaml.Node {
+	if parent.Kind == yaml.MappingNode && child.Kind == yaml.MappingNode {
+		merged := *parent
+		merged.Content = append([]*yaml.Node(nil), parent.Content...)
+		for i := 0; i+1 < len(child.Content); i += 2 {
+			key, val := child.Content[i], child.Content[i+1]
+			idx := findMappingKey(&merged, key.Value)
+			if idx == -1 {
+				merged.Content = append(merged.Content, key, val)
+				continue
+			}
+			merged.Content[idx+1] = mergeConfigNodes(merged.Content[idx+1], val)
+		}
+		return &merged
+	}
+	if parent.Kind == yaml.SequenceNode && child.Kind == yaml.SequenceNode &&
+		child.Tag == appendTag {
+		merged := *child
+		merged.Content = append([]*yaml.Node(nil), parent.Content...)
+		merged.Content = append(merged.Content, child.Content...)
+		return &merged
+	}
+	return child
+}
+
+// findMappingKey returns the index of the key 'name' in the content of
+// 'node', a mapping node, or -1 if it's not there
+func findMappingKey(node *yaml.Node, name string) int {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// clearListMarkers removes the "!append" and "!replace" tags from 'node' and
+// its children once they're merged, since they're not YAML types
+func clearListMarkers(node *yaml.Node) {
+	if node.Tag == appendTag || node.Tag == replaceTag {
+		node.Tag = ""
+	}
+	for _, child := range node.Content {
+		clearListMarkers(child)
+	}
+}
+
+// MergeConfigFile returns the config file at 'configFilePath' merged with
+// every config file it extends, as YAML. Paths are not expanded
+func MergeConfigFile(configFilePath string) ([]byte, error) {
+	root, err := loadConfigNode(configFilePath, []string{})
+	if err != nil {
+		return nil, err
+	}
+	clearListMarkers(root)
+	b, err := yaml.Marshal(root)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while printing %s", configFilePath)
+	}
+	return b, nil
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeConfigFile(t *testing.T) {
+	var testcases = []struct {
+		name           string
+		inputFiles     map[string]string
+		expectedConfig string
+		expectedError  string
+	}{
+		{
+			name: "Maps merge, lists and scalars replace",
+			inputFiles: map[string]string{
+				"base.yaml": `seed: 1
+opt-path: /base/opt
+opt-env-vars:
+  A: a
+  B: b
+opt-cli-args: [--lowerswitch]
+`,
+				"config.yaml": `extends: base.yaml
+seed: 2
+opt-env-vars:
+  B: c
+opt-cli-args: [--mem2reg]
+`,
+			},
+			expectedConfig: `seed: 2
+opt-path: /base/opt
+opt-env-vars:
+    A: a
+    B: c
+opt-cli-args: [--mem2reg]
+`,
+		},
+		{
+			name: "Append and replace markers",
+			inputFiles: map[string]string{
+				"base.yaml": `opt-cli-args: [--lowerswitch]
+stages:
+  - name: a
+`,
+				"config.yaml": `extends: base.yaml
+opt-cli-args: !append [--mem2reg]
+stages: !replace
+  - name: b
+`,
+			},
+			expectedConfig: `opt-cli-args: [--lowerswitch, --mem2reg]
+stages:
+  - name: b
+`,
+		},
+		{
+			name: "Chained and relative to the extending file",
+			inputFiles: map[string]string{
+				"common/base.yaml": `seed: 1
+opt-cli-args: [--lowerswitch]
+`,
+				"common/ios.yaml": `extends: base.yaml
+opt-path: /ios/opt
+`,
+				"config.yaml": `extends: common/ios.yaml
+opt-cli-args: !append [--mem2reg]
+`,
+			},
+			expectedConfig: `seed: 1
+opt-cli-args: [--lowerswitch, --mem2reg]
+opt-path: /ios/opt
+`,
+		},
+		{
+			name: "Cycle",
+			inputFiles: map[string]string{
+				"base.yaml":   "extends: config.yaml\nseed: 1\n",
+				"config.yaml": "extends: base.yaml\nseed: 2\n",
+			},
+			expectedError: "config.yaml extends itself",
+		},
+		{
+			name: "Unknown key in the extended file",
+			inputFiles: map[string]string{
+				"base.yaml":   "seed: 1\nopt-cli-arg: [--lowerswitch]\n",
+				"config.yaml": "extends: base.yaml\n",
+			},
+			expectedError: `base.yaml: line 2, column 1: unknown key "opt-cli-arg"`,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tc.inputFiles {
+				path := filepath.Join(dir, name)
+				require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+				require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+			}
+			b, err := MergeConfigFile(filepath.Join(dir, "config.yaml"))
+			if len(tc.expectedError) != 0 {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedConfig, string(b))
+		})
+	}
+}
+
+func TestLoadConfigFileExtends(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(
+		filepath.Join(dir, "base.yaml"),
+		[]byte(`seed: 1
+clang-dir-path: /
+opt-path: /
+opt-cli-args: [--lowerswitch]
+`),
+		0644,
+	)
+	require.NoError(t, err)
+	configFilePath := filepath.Join(dir, "config.yaml")
+	err = os.WriteFile(
+		configFilePath,
+		[]byte("extends: base.yaml\nopt-cli-args: !append [--mem2reg]\n"),
+		0644,
+	)
+	require.NoError(t, err)
+	cfg, err := LoadConfigFile(configFilePath)
+	require.NoError(t, err)
+	require.Equal(t, Seed(1), cfg.Seed)
+	require.Equal(t, []string{"--lowerswitch", "--mem2reg"}, cfg.OptCLIArgs)
+}
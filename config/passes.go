@@ -0,0 +1,486 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/errors/v5"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Pass is a pass of a new pass manager pipeline (e.g., "mem2reg" or
+// "loop-unroll<O3>"), or an adaptor (e.g., "function" or "module") that runs
+// its nested Passes
+type Pass struct {
+	// Name is the name of the pass, with its params if any
+	Name string
+	// Passes are the nested passes of an adaptor
+	Passes Passes
+}
+
+// Passes is a new pass manager pipeline, as opt's -passes takes it. In a
+// config file, it's either a pipeline string (e.g.,
+// "function(mem2reg),my-pass") or a list of pipeline strings and adaptors,
+// which are maps with a single key:
+//
+//	passes:
+//	  - my-module-pass
+//	  - function:
+//	      - mem2reg
+//	      - loop(licm)
+type Passes []Pass
+
+// builtinPassNames are the names that opt's pipeline parser knows but
+// 'opt --print-passes' doesn't list: adaptors and pipeline keywords
+var builtinPassNames = map[string]bool{
+	"module":           true,
+	"cgscc":            true,
+	"function":         true,
+	"loop":             true,
+	"loop-mssa":        true,
+	"devirt":           true,
+	"repeat":           true,
+	"require":          true,
+	"invalidate":       true,
+	"default":          true,
+	"thinlto-pre-link": true,
+	"thinlto":          true,
+	"lto-pre-link":     true,
+	"lto":              true,
+}
+
+func (passes *Passes) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		parsed, err := ParsePasses(node.Value)
+		if err != nil {
+			return err
+		}
+		*passes = parsed
+		return nil
+	case yaml.SequenceNode:
+	default:
+		return errors.Newf(
+			"line %d: passes must be a pipeline string or a list",
+			node.Line,
+		)
+	}
+	ret := Passes{}
+	for _, item := range node.Content {
+		switch item.Kind {
+		case yaml.ScalarNode:
+			parsed, err := ParsePasses(item.Value)
+			if err != nil {
+				return err
+			}
+			ret = append(ret, parsed...)
+		case yaml.MappingNode:
+			if len(item.Content) != 2 {
+				return errors.Newf(
+					"line %d: an adaptor must have a single key, like 'function: [mem2reg]'",
+					item.Line,
+				)
+			}
+			adaptor := Pass{Name: item.Content[0].Value}
+			err := item.Content[1].Decode(&adaptor.Passes)
+			if err != nil {
+				return err
+			}
+			if len(adaptor.Passes) == 0 {
+				return errors.Newf(
+					"line %d: adaptor %s has no passes",
+					item.Line,
+					adaptor.Name,
+				)
+			}
+			ret = append(ret, adaptor)
+		default:
+			return errors.Newf(
+				"line %d: a pass must be a pipeline string or an adaptor",
+				item.Line,
+			)
+		}
+	}
+	*passes = ret
+	return nil
+}
+
+// ParsePasses parses the new pass manager pipeline 'pipeline' (e.g.,
+// "function(mem2reg,instcombine),my-pass"). Spaces around pass names are
+// ignored
+func ParsePasses(pipeline string) (Passes, error) {
+	p := &passParser{s: pipeline}
+	passes, err := p.parseList()
+	if err != nil {
+		return nil, errors.Wrapf(err, "bad pipeline %q", pipeline)
+	}
+	if p.pos != len(p.s) {
+		return nil, errors.Newf("bad pipeline %q: unexpected ')' at offset %d", pipeline, p.pos)
+	}
+	return passes, nil
+}
+
+// passParser parses a pipeline string. See ParsePasses()
+type passParser struct {
+	s   string
+	pos int
+}
+
+// parseList parses a comma-separated list of passes, up to the end of the
+// string or a ')'
+func (p *passParser) parseList() (Passes, error) {
+	passes := Passes{}
+	for {
+		pass, err := p.parsePass()
+		if err != nil {
+			return nil, err
+		}
+		passes = append(passes, pass)
+		if p.pos == len(p.s) || p.s[p.pos] == ')' {
+			return passes, nil
+		}
+		// parsePass() stops at ',', '(' or ')'
+		p.pos++
+	}
+}
+
+// parsePass parses a pass name, with its params (e.g., "<O3>") and its
+// nested passes (e.g., "(mem2reg)") if any
+func (p *passParser) parsePass() (Pass, error) {
+	start := p.pos
+	angleDepth := 0
+loop:
+	for ; p.pos < len(p.s); p.pos++ {
+		switch c := p.s[p.pos]; {
+		case c == '<':
+			angleDepth++
+		case c == '>' && angleDepth > 0:
+			angleDepth--
+		case angleDepth == 0 && (c == ',' || c == '(' || c == ')'):
+			break loop
+		}
+	}
+	if angleDepth != 0 {
+		return Pass{}, errors.Newf("missing '>' after offset %d", start)
+	}
+	pass := Pass{Name: strings.TrimSpace(p.s[start:p.pos])}
+	if len(pass.Name) == 0 {
+		return Pass{}, errors.Newf("missing pass name at offset %d", start)
+	}
+	if p.pos == len(p.s) || p.s[p.pos] != '(' {
+		return pass, nil
+	}
+	p.pos++
+	var err error
+	pass.Passes, err = p.parseList()
+	if err != nil {
+		return Pass{}, err
+	}
+	if p.pos == len(p.s) {
+		return Pass{}, errors.Newf("missing ')' after %s", pass.Name)
+	}
+	p.pos++
+	return pass, nil
+}
+
+// String returns 'pass' as a pipeline string (e.g., "function(mem2reg)")
+func (pass Pass) String() string {
+	if len(pass.Passes) == 0 {
+		return pass.Name
+	}
+	return pass.Name + "(" + pass.Passes.String() + ")"
+}
+
+// String returns 'passes' as a pipeline string, as opt's -passes takes it
+func (passes Passes) String() string {
+	strs := []string{}
+	for _, pass := range passes {
+		strs = append(strs, pass.String())
+	}
+	return strings.Join(strs, ",")
+}
+
+// all returns every pass in 'passes', adaptors and nested passes included
+func (passes Passes) all() []Pass {
+	ret := []Pass{}
+	for _, pass := range passes {
+		ret = append(ret, pass)
+		ret = append(ret, pass.Passes.all()...)
+	}
+	return ret
+}
+
+// passBaseName returns 'name' without its params (e.g., "loop-unroll" for
+// "loop-unroll<O3>")
+func passBaseName(name string) string {
+	if i := strings.IndexByte(name, '<'); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// CheckPasses checks that opt knows every pass in the Passes of every opt
+// stage in 'stages': its name must be listed by 'opt --print-passes' or be a
+// builtin adaptor or keyword (see builtinPassNames). The results of running
+// opt are cached in 'cachePath', if it's not empty (see passCheckCache).
+//
+// XXX Plugins register their passes through callbacks, which
+// 'opt --print-passes' doesn't list. If a stage has plugins, passes that
+// aren't listed are checked by running opt with the plugins on an empty
+// module instead
+func CheckPasses(stages []Stage, cachePath string) error {
+	cache := readPassCheckCache(cachePath)
+	defer cache.write()
+	for i, stage := range stages {
+		if stage.Kind() != StageKind_Opt || len(stage.Passes) == 0 {
+			continue
+		}
+		knownNames, err := cache.getOptPassNames(stage.OptPath)
+		if err != nil {
+			return err
+		}
+		unknownNames := []string{}
+		for _, pass := range stage.Passes.all() {
+			baseName := passBaseName(pass.Name)
+			if knownNames[baseName] || builtinPassNames[baseName] {
+				continue
+			}
+			if len(stage.Plugins) != 0 &&
+				cache.canParsePasses(stage.OptPath, stage.Plugins, pass.String()) {
+				continue
+			}
+			unknownNames = append(unknownNames, pass.Name)
+		}
+		if len(unknownNames) != 0 {
+			return errors.Newf(
+				"stage #%d: unknown passes for %s: %s",
+				i,
+				stage.OptPath,
+				strings.Join(unknownNames, ", "),
+			)
+		}
+	}
+	return nil
+}
+
+// GetPassCheckCachePath returns cfg.PassCheckCachePath, or else
+// "conjunct/pass-checks.json" in the user's cache dir. Returns an empty
+// string if there's no user cache dir
+func (cfg *Config) GetPassCheckCachePath() string {
+	if len(cfg.PassCheckCachePath) != 0 {
+		return cfg.PassCheckCachePath
+	}
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		logrus.Debugf("Not caching pass checks: %v", err)
+		return ""
+	}
+	return filepath.Join(userCacheDir, "conjunct", "pass-checks.json")
+}
+
+// passCheckCache caches the results of running opt in CheckPasses() across
+// invocations. Every entry is valid as long as the mtime and size of opt
+// and of the plugins it loaded don't change.
+//
+// XXX The cache isn't locked: it's replaced atomically, so concurrent
+// invocations at worst drop each other's entries and run opt again
+type passCheckCache struct {
+	// PassNames are the pass names of every opt binary, by path. See
+	// getOptPassNames()
+	PassNames map[string]passCheckCacheEntry `json:"pass-names"`
+	// ParsedPipelines are whether opt can parse a pipeline with plugins, by
+	// opt path, plugin paths and pipeline. See canParsePasses()
+	ParsedPipelines map[string]passCheckCacheEntry `json:"parsed-pipelines"`
+
+	path    string
+	isDirty bool
+}
+
+// passCheckCacheEntry is a result of running opt. See passCheckCache
+type passCheckCacheEntry struct {
+	// Files are the mtime and size of opt and the plugins it loaded, in
+	// order
+	Files []fileStamp `json:"files"`
+	// Names are the pass names listed by 'opt --print-passes'
+	Names []string `json:"names,omitempty"`
+	// CanParse is true if opt could parse the pipeline
+	CanParse bool `json:"can-parse,omitempty"`
+}
+
+// fileStamp is the mtime and size of a file
+type fileStamp struct {
+	ModTime int64 `json:"mtime"`
+	Size    int64 `json:"size"`
+}
+
+// getFileStamps returns the mtime and size of every file in 'paths'
+func getFileStamps(paths []string) ([]fileStamp, error) {
+	stamps := []fileStamp{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		stamps = append(stamps, fileStamp{
+			ModTime: info.ModTime().UnixNano(),
+			Size:    info.Size(),
+		})
+	}
+	return stamps, nil
+}
+
+// readPassCheckCache reads the pass check cache at 'cachePath'. A missing
+// or unreadable cache is empty, and an empty 'cachePath' caches nothing
+func readPassCheckCache(cachePath string) *passCheckCache {
+	cache := &passCheckCache{}
+	if len(cachePath) != 0 {
+		b, err := os.ReadFile(cachePath)
+		if err == nil {
+			err = json.Unmarshal(b, cache)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			logrus.Warnf("Starting pass check cache %s over: %v", cachePath, err)
+			cache = &passCheckCache{}
+		}
+	}
+	if cache.PassNames == nil {
+		cache.PassNames = map[string]passCheckCacheEntry{}
+	}
+	if cache.ParsedPipelines == nil {
+		cache.ParsedPipelines = map[string]passCheckCacheEntry{}
+	}
+	cache.path = cachePath
+	return cache
+}
+
+// write replaces the cache file with 'cache' if it has new entries, through
+// a temp file in the same dir so that readers never see a partial cache
+func (cache *passCheckCache) write() {
+	if len(cache.path) == 0 || !cache.isDirty {
+		return
+	}
+	err := func() error {
+		b, err := json.Marshal(cache)
+		if err != nil {
+			return err
+		}
+		err = os.MkdirAll(filepath.Dir(cache.path), 0755)
+		if err != nil {
+			return err
+		}
+		f, err := os.CreateTemp(filepath.Dir(cache.path), ".pass-checks-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		_, err = f.Write(b)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		return os.Rename(f.Name(), cache.path)
+	}()
+	if err != nil {
+		logrus.Warnf("Failed to cache pass checks in %s: %v", cache.path, err)
+	}
+}
+
+// lookup returns the entry of 'key' in 'entries' if it's still valid for
+// 'stamps'
+func (cache *passCheckCache) lookup(
+	entries map[string]passCheckCacheEntry,
+	key string,
+	stamps []fileStamp,
+) (passCheckCacheEntry, bool) {
+	entry, ok := entries[key]
+	if !ok || stamps == nil || !reflect.DeepEqual(entry.Files, stamps) {
+		return passCheckCacheEntry{}, false
+	}
+	return entry, true
+}
+
+// store sets the entry of 'key' in 'entries', unless the stamps of its
+// files are unknown
+func (cache *passCheckCache) store(
+	entries map[string]passCheckCacheEntry,
+	key string,
+	entry passCheckCacheEntry,
+) {
+	if entry.Files == nil {
+		return
+	}
+	entries[key] = entry
+	cache.isDirty = true
+}
+
+// getOptPassNames returns the names of the passes and analyses listed by
+// 'opt --print-passes', without their params
+func (cache *passCheckCache) getOptPassNames(
+	optPath string,
+) (map[string]bool, error) {
+	stamps, _ := getFileStamps([]string{optPath})
+	entry, ok := cache.lookup(cache.PassNames, optPath, stamps)
+	if !ok {
+		b, err := exec.Command(optPath, "--print-passes").CombinedOutput()
+		if err != nil {
+			return nil, errors.Wrapf(
+				err,
+				"while running %s --print-passes: %s",
+				optPath,
+				string(b),
+			)
+		}
+		// Every pass is indented under a header like "Module passes:"
+		entry = passCheckCacheEntry{Files: stamps, Names: []string{}}
+		for _, line := range strings.Split(string(b), "\n") {
+			if !strings.HasPrefix(line, " ") {
+				continue
+			}
+			entry.Names = append(entry.Names, passBaseName(strings.TrimSpace(line)))
+		}
+		cache.store(cache.PassNames, optPath, entry)
+	}
+	names := map[string]bool{}
+	for _, name := range entry.Names {
+		names[name] = true
+	}
+	return names, nil
+}
+
+// canParsePasses returns true if the opt binary at 'optPath' with 'plugins'
+// loaded can parse the pipeline 'pipeline'
+func (cache *passCheckCache) canParsePasses(
+	optPath string,
+	plugins []string,
+	pipeline string,
+) bool {
+	args := []string{}
+	for _, plugin := range plugins {
+		args = append(args, "-load-pass-plugin="+plugin)
+	}
+	args = append(args, "-passes="+pipeline, "-disable-output")
+	key := strings.Join(append([]string{optPath}, args...), " ")
+	stamps, _ := getFileStamps(append([]string{optPath}, plugins...))
+	if entry, ok := cache.lookup(cache.ParsedPipelines, key, stamps); ok {
+		return entry.CanParse
+	}
+	cmd := exec.Command(optPath, args...)
+	// An empty input is an empty module
+	cmd.Stdin = strings.NewReader("")
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		logrus.Debugf("%s can't parse %s: %v: %s", optPath, pipeline, err, string(b))
+	}
+	cache.store(cache.ParsedPipelines, key, passCheckCacheEntry{
+		Files:    stamps,
+		CanParse: err == nil,
+	})
+	return err == nil
+}
@@ -0,0 +1,296 @@
+package config
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParsePasses(t *testing.T) {
+	var testcases = []struct {
+		name             string
+		inputPipeline    string
+		expectedPasses   Passes
+		expectedPipeline string
+		expectedError    string
+	}{
+		{
+			name:           "Single pass",
+			inputPipeline:  "mem2reg",
+			expectedPasses: Passes{{Name: "mem2reg"}},
+		},
+		{
+			name:          "Nested adaptors and params",
+			inputPipeline: "my-pass,function(mem2reg,loop(licm)),cgscc(devirt<4>(inline)),loop-unroll<O3>",
+			expectedPasses: Passes{
+				{Name: "my-pass"},
+				{Name: "function", Passes: Passes{
+					{Name: "mem2reg"},
+					{Name: "loop", Passes: Passes{{Name: "licm"}}},
+				}},
+				{Name: "cgscc", Passes: Passes{
+					{Name: "devirt<4>", Passes: Passes{{Name: "inline"}}},
+				}},
+				{Name: "loop-unroll<O3>"},
+			},
+		},
+		{
+			name:          "Params with commas and parens",
+			inputPipeline: "simplifycfg<bonus-inst-threshold=2;a(b),c>",
+			expectedPasses: Passes{
+				{Name: "simplifycfg<bonus-inst-threshold=2;a(b),c>"},
+			},
+		},
+		{
+			name:             "Spaces are ignored",
+			inputPipeline:    "function( mem2reg, instcombine )",
+			expectedPipeline: "function(mem2reg,instcombine)",
+			expectedPasses: Passes{
+				{Name: "function", Passes: Passes{
+					{Name: "mem2reg"},
+					{Name: "instcombine"},
+				}},
+			},
+		},
+		{
+			name:          "Empty",
+			inputPipeline: "",
+			expectedError: "missing pass name",
+		},
+		{
+			name:          "Missing ')'",
+			inputPipeline: "function(mem2reg",
+			expectedError: "missing ')' after function",
+		},
+		{
+			name:          "Extra ')'",
+			inputPipeline: "mem2reg)",
+			expectedError: "unexpected ')'",
+		},
+		{
+			name:          "Missing '>'",
+			inputPipeline: "loop-unroll<O3",
+			expectedError: "missing '>'",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			passes, err := ParsePasses(tc.inputPipeline)
+			if len(tc.expectedError) != 0 {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedPasses, passes)
+			expectedPipeline := tc.expectedPipeline
+			if len(expectedPipeline) == 0 {
+				expectedPipeline = tc.inputPipeline
+			}
+			require.Equal(t, expectedPipeline, passes.String())
+		})
+	}
+}
+
+func TestUnmarshalPasses(t *testing.T) {
+	var testcases = []struct {
+		name             string
+		inputYAML        string
+		expectedPipeline string
+		expectedError    string
+	}{
+		{
+			name:             "Pipeline string",
+			inputYAML:        `passes: "function(mem2reg),my-pass"`,
+			expectedPipeline: "function(mem2reg),my-pass",
+		},
+		{
+			name: "Structured list",
+			inputYAML: `passes:
+  - my-module-pass
+  - function:
+      - mem2reg
+      - loop(licm)
+      - loop-mssa: [licm]
+  - module: inline-wrapper,globaldce
+`,
+			expectedPipeline: "my-module-pass," +
+				"function(mem2reg,loop(licm),loop-mssa(licm))," +
+				"module(inline-wrapper,globaldce)",
+		},
+		{
+			name: "Adaptor with two keys",
+			inputYAML: `passes:
+  - function: [mem2reg]
+    module: [globaldce]
+`,
+			expectedError: "an adaptor must have a single key",
+		},
+		{
+			name: "Adaptor without passes",
+			inputYAML: `passes:
+  - function: []
+`,
+			expectedError: "adaptor function has no passes",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			stage := Stage{}
+			err := yaml.Unmarshal([]byte(tc.inputYAML), &stage)
+			if len(tc.expectedError) != 0 {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedPipeline, stage.Passes.String())
+		})
+	}
+}
+
+func TestGetOptCLIArgs(t *testing.T) {
+	stage := Stage{
+		Plugins:    []string{"/a.so", "/b.so"},
+		Passes:     Passes{{Name: "function", Passes: Passes{{Name: "mem2reg"}}}},
+		OptCLIArgs: []string{"-my-pass-level=3"},
+	}
+	require.Equal(t, []string{
+		"-load-pass-plugin=/a.so",
+		"-load-pass-plugin=/b.so",
+		"-passes=function(mem2reg)",
+		"-my-pass-level=3",
+	}, stage.GetOptCLIArgs())
+}
+
+func TestCheckPasses(t *testing.T) {
+	optPath, err := exec.LookPath("opt")
+	require.NoError(t, err)
+
+	var testcases = []struct {
+		name          string
+		inputPipeline string
+		expectedError string
+	}{
+		{
+			name:          "Known passes",
+			inputPipeline: "function(mem2reg,loop-mssa(licm)),cgscc(devirt<4>(inline)),require<domtree>,globaldce",
+		},
+		{
+			name:          "Unknown passes",
+			inputPipeline: "function(mem2reg,mem2regg),not-a-pass<x>",
+			expectedError: "unknown passes for " + optPath + ": mem2regg, not-a-pass<x>",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			passes, err := ParsePasses(tc.inputPipeline)
+			require.NoError(t, err)
+			err = CheckPasses([]Stage{
+				{Command: []string{"true"}},
+				{OptPath: optPath, Passes: passes},
+			}, "")
+			if len(tc.expectedError) != 0 {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "stage #1: "+tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestCheckPassesCache(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "opt.log")
+	optPath := filepath.Join(dir, "opt")
+	// Lists mem2reg, and parses my-pass only with a plugin
+	err := os.WriteFile(optPath, []byte(`#!/bin/sh
+echo "$@" >> `+logPath+`
+case "$*" in
+--print-passes) printf 'Module passes:\n  mem2reg\n' ;;
+*-load-pass-plugin=*-passes=my-pass*) ;;
+*) exit 1 ;;
+esac
+`), 0755)
+	require.NoError(t, err)
+	pluginPath := filepath.Join(dir, "MyPlugin.so")
+	require.NoError(t, os.WriteFile(pluginPath, []byte("v1"), 0644))
+	cachePath := filepath.Join(dir, "cache", "pass-checks.json")
+	stages := []Stage{{
+		OptPath: optPath,
+		Plugins: []string{pluginPath},
+		Passes:  Passes{{Name: "mem2reg"}, {Name: "my-pass"}},
+	}}
+	getOptRuns := func() int {
+		b, err := os.ReadFile(logPath)
+		require.NoError(t, err)
+		return len(strings.Split(strings.TrimSpace(string(b)), "\n"))
+	}
+
+	// Opt only runs once across invocations
+	require.NoError(t, CheckPasses(stages, cachePath))
+	require.Equal(t, 2, getOptRuns())
+	require.NoError(t, CheckPasses(stages, cachePath))
+	require.Equal(t, 2, getOptRuns())
+
+	// A changed plugin is probed again
+	require.NoError(t, os.WriteFile(pluginPath, []byte("v2!"), 0644))
+	require.NoError(t, CheckPasses(stages, cachePath))
+	require.Equal(t, 3, getOptRuns())
+
+	// So is an unknown pass, which is cached as well
+	stages[0].Passes = Passes{{Name: "not-a-pass"}}
+	require.Error(t, CheckPasses(stages, cachePath))
+	require.Error(t, CheckPasses(stages, cachePath))
+	require.Equal(t, 4, getOptRuns())
+}
+
+func TestLoadConfigFilePasses(t *testing.T) {
+	dir := t.TempDir()
+	pluginPath := filepath.Join(dir, "MyPlugin.so")
+	require.NoError(t, os.WriteFile(pluginPath, []byte(""), 0644))
+	configFilePath := filepath.Join(dir, "config.yaml")
+	err := os.WriteFile(
+		configFilePath,
+		[]byte(`seed: 1
+clang-dir-path: /
+opt-path: /
+plugins: [`+pluginPath+`]
+passes:
+  - function: [mem2reg]
+  - my-pass
+`),
+		0644,
+	)
+	require.NoError(t, err)
+	cfg, err := LoadConfigFile(configFilePath)
+	require.NoError(t, err)
+	stages := cfg.GetStages()
+	require.Len(t, stages, 1)
+	require.Equal(t, []string{
+		"-load-pass-plugin=" + pluginPath,
+		"-passes=function(mem2reg),my-pass",
+	}, stages[0].GetOptCLIArgs())
+
+	// Opt fields can't be used with command stages
+	err = os.WriteFile(
+		configFilePath,
+		[]byte(`seed: 1
+clang-dir-path: /
+stages:
+  - command: [cp, "{input}", "{output}"]
+    passes: mem2reg
+`),
+		0644,
+	)
+	require.NoError(t, err)
+	_, err = LoadConfigFile(configFilePath)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "command can't be used with opt fields")
+}
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"sort"
+
+	"github.com/afjoseph/conjunct/invocation"
+	"github.com/afjoseph/conjunct/util"
+	"github.com/go-playground/errors/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// Profile is a named set of opt settings that replaces the top-level ones
+// (i.e., Stages or the single-opt shorthand) when it's selected. See
+// Config.SelectProfile()
+type Profile struct {
+	// OptLevels are the optimization levels (e.g., "-O2" or "-Oz") that
+	// select this profile when there's no --conjunct-profile. See
+	// invocation.OptLevels
+	OptLevels []string `yaml:"opt-levels"`
+	// OptPath, OptEnvVars, OptCLIArgs, Plugins, Passes and Stages are like
+	// the top-level ones. OptPath defaults to the top-level one. A profile
+	// with neither OptCLIArgs, OptEnvVars, Plugins, Passes nor Stages runs
+	// no stage
+	OptPath    string            `yaml:"opt-path"`
+	OptEnvVars map[string]string `yaml:"opt-env-vars"`
+	OptCLIArgs []string          `yaml:"opt-cli-args"`
+	Plugins    []string          `yaml:"plugins"`
+	Passes     Passes            `yaml:"passes"`
+	Stages     []Stage           `yaml:"stages"`
+}
+
+// GetStages returns the stages of 'profile', in order. See Config.GetStages()
+func (profile *Profile) GetStages() []Stage {
+	if len(profile.Stages) != 0 {
+		return profile.Stages
+	}
+	stage := profile.getShorthandStage()
+	if !stage.hasOptFields() {
+		return nil
+	}
+	return []Stage{stage}
+}
+
+// getShorthandStage returns the single-opt fields of 'profile' as one stage
+func (profile *Profile) getShorthandStage() Stage {
+	return Stage{
+		OptPath:    profile.OptPath,
+		OptEnvVars: profile.OptEnvVars,
+		OptCLIArgs: profile.OptCLIArgs,
+		Plugins:    profile.Plugins,
+		Passes:     profile.Passes,
+	}
+}
+
+// expand validates 'profile' and expands its paths in place. Opt stages and
+// the shorthand without an opt-path use 'defaultOptPath'
+func (profile *Profile) expand(defaultOptPath string) (err error) {
+	for _, level := range profile.OptLevels {
+		if !isKnownOptLevel(level) {
+			return errors.Newf(
+				"unknown opt level %s: must be one of %+v",
+				level,
+				invocation.OptLevels,
+			)
+		}
+	}
+	shorthandStage := profile.getShorthandStage()
+	isShorthand := shorthandStage.hasOptFields()
+	if len(profile.Stages) != 0 && isShorthand {
+		return errors.New(
+			"opt-cli-args, opt-env-vars, plugins and passes can't be used with stages",
+		)
+	}
+	err = expandPaths(profile.Plugins, "plugin")
+	if err != nil {
+		return err
+	}
+	if len(profile.OptPath) == 0 {
+		profile.OptPath = defaultOptPath
+	}
+	if len(profile.OptPath) != 0 {
+		profile.OptPath, err = util.ExpandPath(profile.OptPath, false)
+		if err != nil {
+			return errors.Wrapf(
+				err,
+				"failed to expand opt path: %s",
+				profile.OptPath,
+			)
+		}
+	}
+	if isShorthand && len(profile.OptPath) == 0 {
+		return errors.New("missing opt-path")
+	}
+	return expandStages(profile.Stages, profile.OptPath)
+}
+
+func isKnownOptLevel(level string) bool {
+	for _, l := range invocation.OptLevels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// validateProfiles checks that no opt level selects more than one profile
+func validateProfiles(profiles map[string]*Profile) error {
+	profileByLevel := map[string]string{}
+	for _, name := range sortedProfileNames(profiles) {
+		for _, level := range profiles[name].OptLevels {
+			if other, ok := profileByLevel[level]; ok {
+				return errors.Newf(
+					"opt level %s selects both profiles %s and %s",
+					level,
+					other,
+					name,
+				)
+			}
+			profileByLevel[level] = name
+		}
+	}
+	return nil
+}
+
+func sortedProfileNames(profiles map[string]*Profile) []string {
+	names := []string{}
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SelectProfile selects the profile of 'cfg' to use for the compiler
+// invocation with 'args':
+//   - If 'profileName' is set (i.e., --conjunct-profile), that profile
+//   - Else, the profile with the optimization level of 'args' (see
+//     invocation.GetOptLevel()) in its OptLevels, if any
+//
+// Returns an error if 'profileName' is not a profile of 'cfg'
+func (cfg *Config) SelectProfile(profileName string, args []string) error {
+	if len(profileName) != 0 {
+		if _, ok := cfg.Profiles[profileName]; !ok {
+			return errors.Newf("unknown profile %s", profileName)
+		}
+		logrus.Debugf("Using profile %s from --conjunct-profile", profileName)
+		cfg.Profile = profileName
+		return nil
+	}
+	optLevel := invocation.GetOptLevel(args)
+	for _, name := range sortedProfileNames(cfg.Profiles) {
+		for _, level := range cfg.Profiles[name].OptLevels {
+			if level == optLevel {
+				logrus.Debugf("Using profile %s for %s", name, optLevel)
+				cfg.Profile = name
+				return nil
+			}
+		}
+	}
+	logrus.Debugf("No profile for %s: using the top-level opt settings", optLevel)
+	return nil
+}
@@ -0,0 +1,206 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectProfile(t *testing.T) {
+	configFilePath := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(
+		configFilePath,
+		[]byte(`seed: 1
+clang-dir-path: /
+opt-path: /
+opt-cli-args: [--lowerswitch]
+profiles:
+  debug:
+    opt-levels: [-O0, -O1]
+  release:
+    opt-levels: [-O2, -O3, -Os, -Oz]
+    stages:
+      - name: heavy
+        opt-cli-args: [--mem2reg]
+  size:
+    opt-cli-args: [--instcombine]
+`),
+		0644,
+	)
+	require.NoError(t, err)
+
+	var testcases = []struct {
+		name            string
+		inputArgs       []string
+		expectedProfile string
+		expectedStages  []Stage
+		expectedError   string
+	}{
+		{
+			name:            "Picked from -O",
+			inputArgs:       []string{"-O2", "-c", "foo.c"},
+			expectedProfile: "release",
+			expectedStages: []Stage{
+				{Name: "heavy", OptPath: "/", OptCLIArgs: []string{"--mem2reg"}},
+			},
+		},
+		{
+			name:            "Profile without stages runs none",
+			inputArgs:       []string{"-c", "foo.c"},
+			expectedProfile: "debug",
+			expectedStages:  nil,
+		},
+		{
+			name: "Flag wins over -O",
+			inputArgs: []string{
+				"--conjunct-profile=size", "-O2", "-c", "foo.c",
+			},
+			expectedProfile: "size",
+			expectedStages: []Stage{
+				{OptPath: "/", OptCLIArgs: []string{"--instcombine"}},
+			},
+		},
+		{
+			name:            "No matching profile",
+			inputArgs:       []string{"-Ofast", "-c", "foo.c"},
+			expectedProfile: "",
+			expectedStages: []Stage{
+				{OptPath: "/", OptCLIArgs: []string{"--lowerswitch"}},
+			},
+		},
+		{
+			name:          "Unknown profile",
+			inputArgs:     []string{"--conjunct-profile", "nope", "-c", "foo.c"},
+			expectedError: "unknown profile nope",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := append(
+				[]string{"--conjunct-config-path", configFilePath},
+				tc.inputArgs...,
+			)
+			actualArgs, cfg, err := ExtractConfigFromArgs(args)
+			if len(tc.expectedError) != 0 {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedProfile, cfg.Profile)
+			require.Equal(t, tc.expectedStages, cfg.GetStages())
+			for _, arg := range actualArgs {
+				require.NotContains(t, arg, "--conjunct-profile")
+			}
+		})
+	}
+}
+
+func TestExtractConfigRejectsProfiles(t *testing.T) {
+	var testcases = []struct {
+		name          string
+		inputProfiles string
+		expectedError string
+	}{
+		{
+			name: "Unknown opt level",
+			inputProfiles: `  release:
+    opt-levels: [O2]
+`,
+			expectedError: "unknown opt level O2",
+		},
+		{
+			name: "Opt level in two profiles",
+			inputProfiles: `  a:
+    opt-levels: [-O2]
+  b:
+    opt-levels: [-O0, -O2]
+`,
+			expectedError: "opt level -O2 selects both profiles a and b",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			configFilePath := filepath.Join(t.TempDir(), "config.yaml")
+			err := os.WriteFile(
+				configFilePath,
+				[]byte("seed: 1\nclang-dir-path: /\nopt-path: /\nprofiles:\n"+
+					tc.inputProfiles),
+				0644,
+			)
+			require.NoError(t, err)
+			_, err = LoadConfigFile(configFilePath)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.expectedError)
+		})
+	}
+}
+
+func TestGetStagesForSourceWithProfile(t *testing.T) {
+	configFilePath := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(
+		configFilePath,
+		[]byte(`seed: 1
+clang-dir-path: /
+opt-path: /
+opt-cli-args: [--lowerswitch]
+pipelines:
+  heavy:
+    - opt-cli-args: [--mem2reg]
+rules:
+  - glob: "*_hot.c"
+    pipeline: heavy
+  - glob: "third_party/**"
+    skip: true
+profiles:
+  debug:
+    opt-levels: [-O0]
+`),
+		0644,
+	)
+	require.NoError(t, err)
+
+	var testcases = []struct {
+		name           string
+		inputArgs      []string
+		inputPath      string
+		expectedStages []Stage
+		expectedSkip   bool
+	}{
+		{
+			name:      "Rule without a profile",
+			inputArgs: []string{"-O2", "-c", "foo_hot.c"},
+			inputPath: "foo_hot.c",
+			expectedStages: []Stage{
+				{OptPath: "/", OptCLIArgs: []string{"--mem2reg"}},
+			},
+		},
+		{
+			name:           "Profile overrides the rule's pipeline",
+			inputArgs:      []string{"-O0", "-c", "foo_hot.c"},
+			inputPath:      "foo_hot.c",
+			expectedStages: nil,
+		},
+		{
+			name:         "Skip rules still apply with a profile",
+			inputArgs:    []string{"-O0", "-c", "third_party/foo.c"},
+			inputPath:    "third_party/foo.c",
+			expectedSkip: true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := append(
+				[]string{"--conjunct-config-path", configFilePath},
+				tc.inputArgs...,
+			)
+			_, cfg, err := ExtractConfigFromArgs(args)
+			require.NoError(t, err)
+			stages, skip := cfg.GetStagesForSource(tc.inputPath)
+			require.Equal(t, tc.expectedSkip, skip)
+			require.Equal(t, tc.expectedStages, stages)
+		})
+	}
+}
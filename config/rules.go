@@ -0,0 +1,135 @@
+package config
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/errors/v5"
+)
+
+// Rule picks what Conjunct does with source files matching Glob or Regex.
+// Rules are matched in order against the absolute path of the source file:
+// the first matching rule wins.
+type Rule struct {
+	// Glob is a shell-style pattern. '*' and '?' don't match path
+	// separators while '**' does. If the pattern contains no path
+	// separator, it is matched against the basename of the source file
+	Glob string `yaml:"glob"`
+	// Regex is a regular expression matched against the absolute path of the
+	// source file
+	Regex string `yaml:"regex"`
+	// If Skip is true, matching files are compiled with the original clang
+	// without running any stage
+	Skip bool `yaml:"skip"`
+	// Pipeline is the name of the pipeline (see Config.Pipelines) to run on
+	// matching files instead of the default stages
+	Pipeline string `yaml:"pipeline"`
+
+	re *regexp.Regexp
+}
+
+// compile validates 'rule' and compiles its pattern
+func (rule *Rule) compile() (err error) {
+	if (len(rule.Glob) == 0) == (len(rule.Regex) == 0) {
+		return errors.New("exactly one of glob or regex must be set")
+	}
+	if rule.Skip == (len(rule.Pipeline) != 0) {
+		return errors.New("exactly one of skip or pipeline must be set")
+	}
+	pattern := rule.Regex
+	if len(rule.Glob) != 0 {
+		glob := rule.Glob
+		// Relative globs with a path separator can match anywhere in the
+		// path: i.e., "third_party/**" matches "/src/third_party/a.c"
+		if strings.ContainsRune(glob, '/') && !filepath.IsAbs(glob) {
+			glob = "**/" + glob
+		}
+		pattern = globToRegex(glob)
+	}
+	rule.re, err = regexp.Compile(pattern)
+	if err != nil {
+		return errors.Wrapf(err, "bad pattern %s", pattern)
+	}
+	return nil
+}
+
+// Match returns true if 'sourceFilepath' matches 'rule'
+func (rule *Rule) Match(sourceFilepath string) bool {
+	if rule.re == nil {
+		if err := rule.compile(); err != nil {
+			return false
+		}
+	}
+	absPath, err := filepath.Abs(sourceFilepath)
+	if err != nil {
+		absPath = filepath.Clean(sourceFilepath)
+	}
+	if len(rule.Glob) != 0 && !strings.ContainsRune(rule.Glob, '/') {
+		return rule.re.MatchString(filepath.Base(absPath))
+	}
+	return rule.re.MatchString(absPath)
+}
+
+// MatchRule returns the first rule in 'cfg' that matches 'sourceFilepath',
+// or nil if no rule matches
+func (cfg *Config) MatchRule(sourceFilepath string) *Rule {
+	for i := range cfg.Rules {
+		if cfg.Rules[i].Match(sourceFilepath) {
+			return &cfg.Rules[i]
+		}
+	}
+	return nil
+}
+
+// GetStagesForSource returns the stages to run on 'sourceFilepath' after
+// applying the rules in 'cfg'. If 'skip' is true, no stage should run and
+// the file should be compiled with the original clang.
+//
+// A selected profile (see SelectProfile()) overrides the pipeline a rule
+// picks, like it overrides Stages. Rules that skip files still apply
+func (cfg *Config) GetStagesForSource(
+	sourceFilepath string,
+) (stages []Stage, skip bool) {
+	rule := cfg.MatchRule(sourceFilepath)
+	if rule == nil {
+		return cfg.GetStages(), false
+	}
+	if rule.Skip {
+		return nil, true
+	}
+	if _, ok := cfg.Profiles[cfg.Profile]; ok {
+		return cfg.GetStages(), false
+	}
+	return cfg.Pipelines[rule.Pipeline], false
+}
+
+// globToRegex converts a shell-style 'glob' to an anchored regular
+// expression.
+//
+// Example:
+//
+//	globToRegex("**/third_party/*.c") // ^(?:.*/)?third_party/[^/]*\.c$
+func globToRegex(glob string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlobToRegex(t *testing.T) {
+	var testcases = []struct {
+		name           string
+		inputGlob      string
+		inputPath      string
+		expectedRetval bool
+	}{
+		{
+			name:           "Star matches within a path component",
+			inputGlob:      "/src/*.c",
+			inputPath:      "/src/a.c",
+			expectedRetval: true,
+		},
+		{
+			name:           "Star doesn't cross path separators",
+			inputGlob:      "/src/*.c",
+			inputPath:      "/src/sub/a.c",
+			expectedRetval: false,
+		},
+		{
+			name:           "Double star crosses path separators",
+			inputGlob:      "/src/**/*.c",
+			inputPath:      "/src/sub/dir/a.c",
+			expectedRetval: true,
+		},
+		{
+			name:           "Double star matches zero directories",
+			inputGlob:      "/src/**/*.c",
+			inputPath:      "/src/a.c",
+			expectedRetval: true,
+		},
+		{
+			name:           "Question mark",
+			inputGlob:      "/src/?.c",
+			inputPath:      "/src/ab.c",
+			expectedRetval: false,
+		},
+		{
+			name:           "Dots are literal",
+			inputGlob:      "/src/a.c",
+			inputPath:      "/src/abc",
+			expectedRetval: false,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			rule := Rule{Glob: tc.inputGlob, Skip: true}
+			require.NoError(t, rule.compile())
+			require.Equal(t, tc.expectedRetval, rule.Match(tc.inputPath))
+		})
+	}
+}
+
+func TestGetStagesForSource(t *testing.T) {
+	defaultStages := []Stage{{Name: "default", OptPath: "/opt"}}
+	lightStages := []Stage{{Name: "light", OptPath: "/opt"}}
+	cfg := &Config{
+		Stages:    defaultStages,
+		Pipelines: map[string][]Stage{"light": lightStages},
+		Rules: []Rule{
+			{Glob: "third_party/**", Skip: true},
+			{Regex: `_test\.(c|cpp)$`, Pipeline: "light"},
+			{Glob: "*.mm", Pipeline: "light"},
+		},
+	}
+	for i := range cfg.Rules {
+		require.NoError(t, cfg.Rules[i].compile())
+	}
+
+	var testcases = []struct {
+		name           string
+		inputPath      string
+		expectedStages []Stage
+		expectedSkip   bool
+	}{
+		{
+			name:           "No rule matches",
+			inputPath:      "/src/main.c",
+			expectedStages: defaultStages,
+		},
+		{
+			name:         "Skipped by relative glob",
+			inputPath:    "/src/third_party/zlib/inflate.c",
+			expectedSkip: true,
+		},
+		{
+			name:           "Pipeline picked by regex",
+			inputPath:      "/src/foo_test.cpp",
+			expectedStages: lightStages,
+		},
+		{
+			name:           "Pipeline picked by basename glob",
+			inputPath:      "/src/ios/View.mm",
+			expectedStages: lightStages,
+		},
+		{
+			name:         "First matching rule wins",
+			inputPath:    "/src/third_party/foo_test.c",
+			expectedSkip: true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			stages, skip := cfg.GetStagesForSource(tc.inputPath)
+			require.Equal(t, tc.expectedSkip, skip)
+			require.Equal(t, tc.expectedStages, stages)
+		})
+	}
+}
+
+func TestRuleCompile(t *testing.T) {
+	var testcases = []struct {
+		name      string
+		inputRule Rule
+	}{
+		{
+			name:      "Missing pattern",
+			inputRule: Rule{Skip: true},
+		},
+		{
+			name:      "Both glob and regex",
+			inputRule: Rule{Glob: "*.c", Regex: ".*", Skip: true},
+		},
+		{
+			name:      "Missing action",
+			inputRule: Rule{Glob: "*.c"},
+		},
+		{
+			name:      "Both skip and pipeline",
+			inputRule: Rule{Glob: "*.c", Skip: true, Pipeline: "light"},
+		},
+		{
+			name:      "Bad regex",
+			inputRule: Rule{Regex: "(", Skip: true},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Error(t, tc.inputRule.compile())
+		})
+	}
+}
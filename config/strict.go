@@ -0,0 +1,112 @@
+package config
+
+import (
+	stderr "errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var yamlUnmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+// checkUnknownKeys returns an error if 'root', the top-level node of a
+// config file, has keys that aren't fields of Config (e.g., typos like
+// "opt-cli-arg"), which yaml.Unmarshal() silently ignores.
+//
+// Every unknown key is reported with its line and column (i.e., "line 3,
+// column 1: unknown key ...")
+func checkUnknownKeys(root *yaml.Node) error {
+	unknownKeys := findUnknownKeys(root, reflect.TypeOf(&Config{}))
+	if len(unknownKeys) == 0 {
+		return nil
+	}
+	msgs := []string{}
+	for _, key := range unknownKeys {
+		msgs = append(msgs, fmt.Sprintf(
+			"line %d, column %d: unknown key %q",
+			key.Line,
+			key.Column,
+			key.Value,
+		))
+	}
+	return stderr.New(strings.Join(msgs, "; "))
+}
+
+// findUnknownKeys returns the key nodes in 'node' that don't match a field
+// of 't', recursively. Types with their own UnmarshalYAML() are not checked
+func findUnknownKeys(node *yaml.Node, t reflect.Type) []*yaml.Node {
+	for t.Kind() == reflect.Pointer {
+		if t.Implements(yamlUnmarshalerType) {
+			return nil
+		}
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(yamlUnmarshalerType) {
+		return nil
+	}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil
+		}
+		return findUnknownKeys(node.Content[0], t)
+	case yaml.AliasNode:
+		return findUnknownKeys(node.Alias, t)
+	case yaml.SequenceNode:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return nil
+		}
+		unknownKeys := []*yaml.Node{}
+		for _, item := range node.Content {
+			unknownKeys = append(unknownKeys, findUnknownKeys(item, t.Elem())...)
+		}
+		return unknownKeys
+	case yaml.MappingNode:
+	default:
+		return nil
+	}
+
+	unknownKeys := []*yaml.Node{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, val := node.Content[i], node.Content[i+1]
+		switch {
+		case key.Value == "<<":
+			// Merge keys (i.e., "<<: *anchor") merge into the same type
+			unknownKeys = append(unknownKeys, findUnknownKeys(val, t)...)
+		case t.Kind() == reflect.Map:
+			unknownKeys = append(unknownKeys, findUnknownKeys(val, t.Elem())...)
+		case t.Kind() == reflect.Struct:
+			fieldType, ok := findYAMLField(t, key.Value)
+			if !ok {
+				unknownKeys = append(unknownKeys, key)
+				continue
+			}
+			unknownKeys = append(unknownKeys, findUnknownKeys(val, fieldType)...)
+		}
+	}
+	return unknownKeys
+}
+
+// findYAMLField returns the type of the field of struct 't' that is decoded
+// from the key 'name'
+func findYAMLField(t reflect.Type, name string) (reflect.Type, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if len(tag) == 0 {
+			tag = strings.ToLower(field.Name)
+		}
+		if tag == name {
+			return field.Type, true
+		}
+	}
+	return nil, false
+}
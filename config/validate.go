@@ -0,0 +1,182 @@
+package config
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-playground/errors/v5"
+)
+
+// ValidateConfigFile loads the config file at 'configFilePath' (see
+// LoadConfigFile()) and checks that every path in it exists:
+//   - clang-dir-path is a directory with an executable clang
+//   - Every opt binary and every command stage binary is executable. Bare
+//     command names are looked up in $PATH
+//   - Every plugin exists, and opt knows every pass (see CheckPasses())
+//   - The directories of failure-report-path and seed-manifest-path exist,
+//     and so do seed-replay-from and every tool in tool-checksums
+//
+// Returns every problem found, or nil if there's none
+func ValidateConfigFile(configFilePath string) []error {
+	config, err := LoadConfigFile(configFilePath)
+	if err != nil {
+		return []error{err}
+	}
+	errs := []error{}
+	info, err := os.Stat(config.ClangDirPath)
+	switch {
+	case err != nil:
+		errs = append(errs, errors.Wrapf(err, "clang-dir-path"))
+	case !info.IsDir():
+		errs = append(errs, errors.Newf(
+			"clang-dir-path: %s is not a directory",
+			config.ClangDirPath,
+		))
+	default:
+		err = checkExecutable(filepath.Join(config.ClangDirPath, "clang"))
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "clang-dir-path"))
+		}
+	}
+	if len(config.OptPath) != 0 {
+		if err := checkExecutable(config.OptPath); err != nil {
+			errs = append(errs, errors.Wrapf(err, "opt-path"))
+		}
+	}
+	errs = append(errs, validateStages(config.Stages, "stages")...)
+	for _, plugin := range config.Plugins {
+		if _, err := os.Stat(plugin); err != nil {
+			errs = append(errs, errors.Wrapf(err, "plugins"))
+		}
+	}
+	for name, stages := range config.Pipelines {
+		errs = append(errs, validateStages(stages, "pipeline "+name)...)
+	}
+	for _, name := range sortedProfileNames(config.Profiles) {
+		profile := config.Profiles[name]
+		errs = append(errs, validateStages(profile.GetStages(), "profile "+name)...)
+	}
+	if len(config.FailureReportPath) != 0 {
+		err := checkParentDir(config.FailureReportPath)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "failure-report-path"))
+		}
+	}
+	if len(config.SeedManifestPath) != 0 {
+		err := checkParentDir(config.SeedManifestPath)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "seed-manifest-path"))
+		}
+	}
+	if len(config.SeedReplayFrom) != 0 {
+		if _, err := os.Stat(config.SeedReplayFrom); err != nil {
+			errs = append(errs, errors.Wrapf(err, "seed-replay-from"))
+		}
+	}
+	for _, path := range sortedKeys(config.ToolChecksums) {
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, errors.Wrapf(err, "tool-checksums"))
+		}
+	}
+	if len(errs) == 0 {
+		// Only run opt once every binary and plugin is known to be valid
+		errs = append(errs, checkAllPasses(config)...)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// checkAllPasses checks the passes of the stages of 'config' and of every
+// pipeline and profile in it. See CheckPasses()
+func checkAllPasses(config *Config) []error {
+	errs := []error{}
+	if err := CheckPasses(config.GetStages(), config.GetPassCheckCachePath()); err != nil {
+		errs = append(errs, errors.Wrapf(err, "stages"))
+	}
+	for name, stages := range config.Pipelines {
+		if err := CheckPasses(stages, config.GetPassCheckCachePath()); err != nil {
+			errs = append(errs, errors.Wrapf(err, "pipeline %s", name))
+		}
+	}
+	for _, name := range sortedProfileNames(config.Profiles) {
+		err := CheckPasses(
+			config.Profiles[name].GetStages(),
+			config.GetPassCheckCachePath(),
+		)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "profile %s", name))
+		}
+	}
+	return errs
+}
+
+// validateStages checks that the binary of every stage in 'stages' is
+// executable and that their plugins and inputs exist. 'where' says where the stages
+// are in the config file
+func validateStages(stages []Stage, where string) []error {
+	errs := []error{}
+	for i, stage := range stages {
+		for _, plugin := range append(stage.Plugins, stage.Inputs...) {
+			if _, err := os.Stat(plugin); err != nil {
+				errs = append(errs, errors.Wrapf(err, "%s: stage #%d", where, i))
+			}
+		}
+		binaryPath := stage.OptPath
+		if stage.Kind() == StageKind_Command {
+			binaryPath = stage.Command[0]
+			if !strings.ContainsRune(binaryPath, filepath.Separator) {
+				_, err := exec.LookPath(binaryPath)
+				if err != nil {
+					errs = append(errs, errors.Wrapf(err, "%s: stage #%d", where, i))
+				}
+				continue
+			}
+		}
+		if err := checkExecutable(binaryPath); err != nil {
+			errs = append(errs, errors.Wrapf(err, "%s: stage #%d", where, i))
+		}
+	}
+	return errs
+}
+
+// checkParentDir returns an error if the directory of 'path' doesn't exist
+func checkParentDir(path string) error {
+	dir := filepath.Dir(path)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.Newf("%s is not a directory", dir)
+	}
+	return nil
+}
+
+// checkExecutable returns an error if 'path' is not an executable file
+func checkExecutable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return errors.Newf("%s is a directory", path)
+	}
+	if info.Mode().Perm()&0111 == 0 {
+		return errors.Newf("%s is not executable", path)
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	clangDirPath := filepath.Join(dir, "bin")
+	require.NoError(t, os.Mkdir(clangDirPath, 0755))
+	clangPath := filepath.Join(clangDirPath, "clang")
+	require.NoError(t, os.WriteFile(clangPath, []byte("#!/bin/sh\n"), 0755))
+	optPath := filepath.Join(clangDirPath, "opt")
+	require.NoError(t, os.WriteFile(optPath, []byte("#!/bin/sh\n"), 0755))
+	notExecutablePath := filepath.Join(dir, "not-executable")
+	require.NoError(t, os.WriteFile(notExecutablePath, []byte(""), 0644))
+	realOptPath, err := exec.LookPath("opt")
+	require.NoError(t, err)
+
+	var testcases = []struct {
+		name           string
+		inputConfig    string
+		expectedErrors []string
+	}{
+		{
+			name: "Valid",
+			inputConfig: `seed: 1
+clang-dir-path: ` + clangDirPath + `
+opt-path: ` + optPath + `
+stages:
+  - opt-cli-args: [--lowerswitch]
+  - command: [sh, -c, "cp {input} {output}"]
+`,
+		},
+		{
+			name: "Unknown key",
+			inputConfig: `seed: 1
+clang-dir-path: ` + clangDirPath + `
+opt-pth: ` + optPath + `
+`,
+			expectedErrors: []string{`line 3, column 1: unknown key "opt-pth"`},
+		},
+		{
+			name: "Unknown pass",
+			inputConfig: `seed: 1
+clang-dir-path: ` + clangDirPath + `
+opt-path: ` + realOptPath + `
+passes: function(mem2reg,mem2regg)
+`,
+			expectedErrors: []string{"stage #0: unknown passes for " + realOptPath + ": mem2regg"},
+		},
+		{
+			name: "Bad paths",
+			inputConfig: `seed: 1
+clang-dir-path: ` + dir + `
+opt-path: ` + notExecutablePath + `
+failure-report-path: ` + filepath.Join(dir, "missing", "report.jsonl") + `
+pipelines:
+  p:
+    - command: [conjunct-missing-binary]
+`,
+			expectedErrors: []string{
+				"clang-dir-path",
+				notExecutablePath + " is not executable",
+				"pipeline p: stage #0",
+				"failure-report-path",
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			configFilePath := filepath.Join(t.TempDir(), "config.yaml")
+			err := os.WriteFile(configFilePath, []byte(tc.inputConfig), 0644)
+			require.NoError(t, err)
+			errs := ValidateConfigFile(configFilePath)
+			require.Len(t, errs, len(tc.expectedErrors))
+			for i, expectedError := range tc.expectedErrors {
+				require.Contains(t, errs[i].Error(), expectedError)
+			}
+		})
+	}
+}
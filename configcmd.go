@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/afjoseph/conjunct/config"
+	"github.com/go-playground/errors/v5"
+)
+
+const configCommandUsage = `Usage: conjunct config <command> <args>
+
+Commands:
+  validate <file>...  Check that config files are valid and that every path
+                      in them exists. Exits with 1 if any file isn't valid
+  print <file>        Print a config file merged with every config file it
+                      extends
+`
+
+// runConfigCommand runs 'conjunct config <command>' with 'args' being the
+// arguments after "config". Returns the exit code
+func runConfigCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, configCommandUsage)
+		return 2
+	}
+	switch args[0] {
+	case "validate":
+		if len(args) == 1 {
+			fmt.Fprint(os.Stderr, configCommandUsage)
+			return 2
+		}
+		exitCode := 0
+		for _, configFilePath := range args[1:] {
+			errs := config.ValidateConfigFile(configFilePath)
+			if len(errs) == 0 {
+				fmt.Printf("%s: OK\n", configFilePath)
+				continue
+			}
+			exitCode = 1
+			fmt.Fprintf(os.Stderr, "%s: invalid\n", configFilePath)
+			for _, err := range errs {
+				fmt.Fprintf(os.Stderr, "  - %s\n", readableError(err))
+			}
+		}
+		return exitCode
+	case "print":
+		if len(args) != 2 {
+			fmt.Fprint(os.Stderr, configCommandUsage)
+			return 2
+		}
+		b, err := config.MergeConfigFile(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", args[1], readableError(err))
+			return 1
+		}
+		fmt.Print(string(b))
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown config command %s\n\n", args[0])
+		fmt.Fprint(os.Stderr, configCommandUsage)
+		return 2
+	}
+}
+
+// readableError returns the messages of 'err' from the outermost to the
+// innermost (i.e., "prefix: cause"), without the source locations
+// errors.Chain adds
+func readableError(err error) string {
+	chain, ok := err.(errors.Chain)
+	if !ok {
+		return err.Error()
+	}
+	msgs := []string{}
+	for i := len(chain) - 1; i >= 0; i-- {
+		link := chain[i]
+		if len(link.Prefix) != 0 {
+			msgs = append(msgs, link.Prefix)
+		}
+		if link.Err != nil {
+			msgs = append(msgs, link.Err.Error())
+		}
+	}
+	return strings.Join(msgs, ": ")
+}
@@ -0,0 +1,179 @@
+package core
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/afjoseph/conjunct/cache"
+	"github.com/afjoseph/conjunct/config"
+	"github.com/go-playground/errors/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// toolChecksumCacheEntry is the cached checksum of a tool, valid as long as
+// its mtime and size don't change
+type toolChecksumCacheEntry struct {
+	ModTime int64  `json:"mtime"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+}
+
+// checkToolChecksums checks that every tool pinned in cfg.ToolChecksums
+// matches its pin. Checksums are cached in cfg.ToolChecksumCachePath (see
+// getToolChecksumCachePath()) by path, mtime and size, so that a build
+// session only hashes every tool once. The cache is only locked exclusively
+// if it has to be updated. Returns an error listing every tool that doesn't
+// match.
+//
+// XXX A tool swapped for another one with the same size and mtime isn't
+// caught until its cache entry is gone: delete the cache to hash every tool
+// again
+func checkToolChecksums(cfg *config.Config) error {
+	if len(cfg.ToolChecksums) == 0 {
+		return nil
+	}
+	cachePath := getToolChecksumCachePath(cfg)
+	entries := map[string]toolChecksumCacheEntry{}
+	var cacheFile *os.File
+	if len(cachePath) != 0 {
+		var err error
+		cacheFile, entries, err = openToolChecksumCache(cachePath)
+		if err != nil {
+			logrus.Warnf("Not caching tool checksums: %v", err)
+			entries = map[string]toolChecksumCacheEntry{}
+		} else {
+			defer cacheFile.Close()
+			defer unlockFile(cacheFile)
+		}
+	}
+
+	paths := []string{}
+	for path := range cfg.ToolChecksums {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	mismatches := []string{}
+	newEntries := map[string]toolChecksumCacheEntry{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return errors.Wrapf(err, "while checking the checksum of %s", path)
+		}
+		entry, ok := entries[path]
+		if !ok || entry.ModTime != info.ModTime().UnixNano() ||
+			entry.Size != info.Size() {
+			checksum, err := cache.HashFile(path)
+			if err != nil {
+				return errors.Wrapf(err, "while hashing %s", path)
+			}
+			entry = toolChecksumCacheEntry{
+				ModTime: info.ModTime().UnixNano(),
+				Size:    info.Size(),
+				SHA256:  checksum,
+			}
+			newEntries[path] = entry
+			logrus.Debugf("Hashed %s: %s", path, checksum)
+		}
+		if entry.SHA256 != cfg.ToolChecksums[path] {
+			mismatches = append(mismatches, path+" has checksum "+entry.SHA256+
+				", not "+cfg.ToolChecksums[path])
+		}
+	}
+	if cacheFile != nil && len(newEntries) != 0 {
+		err := updateToolChecksumCache(cacheFile, newEntries)
+		if err != nil {
+			logrus.Warnf("Failed to cache tool checksums in %s: %v", cachePath, err)
+		}
+	}
+	if len(mismatches) != 0 {
+		return errors.Newf(
+			"refusing to run with tools that don't match their pinned checksums: %s",
+			strings.Join(mismatches, "; "),
+		)
+	}
+	return nil
+}
+
+// getToolChecksumCachePath returns cfg.ToolChecksumCachePath, or else
+// "conjunct/tool-checksums.json" in the user's cache dir. Returns an empty
+// string if there's no user cache dir.
+//
+// XXX The default isn't in the temp dir since other users could write
+// wrong checksums there
+func getToolChecksumCachePath(cfg *config.Config) string {
+	if len(cfg.ToolChecksumCachePath) != 0 {
+		return cfg.ToolChecksumCachePath
+	}
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		logrus.Debugf("Not caching tool checksums: %v", err)
+		return ""
+	}
+	return filepath.Join(userCacheDir, "conjunct", "tool-checksums.json")
+}
+
+// openToolChecksumCache opens the tool checksum cache at 'cachePath',
+// creating it if needed, takes a shared lock on it and returns its entries
+func openToolChecksumCache(
+	cachePath string,
+) (*os.File, map[string]toolChecksumCacheEntry, error) {
+	err := os.MkdirAll(filepath.Dir(cachePath), 0755)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "while creating the dir of %s", cachePath)
+	}
+	f, err := os.OpenFile(cachePath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "while opening %s", cachePath)
+	}
+	err = lockFileShared(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, errors.Wrapf(err, "while locking %s", cachePath)
+	}
+	return f, readToolChecksumCache(f), nil
+}
+
+// readToolChecksumCache returns the entries of the tool checksum cache 'f',
+// from its start. An unreadable cache is started over
+func readToolChecksumCache(f *os.File) map[string]toolChecksumCacheEntry {
+	entries := map[string]toolChecksumCacheEntry{}
+	_, err := f.Seek(0, io.SeekStart)
+	if err == nil {
+		err = json.NewDecoder(f).Decode(&entries)
+	}
+	if err != nil && err != io.EOF {
+		logrus.Warnf("Starting tool checksum cache %s over: %v", f.Name(), err)
+		entries = map[string]toolChecksumCacheEntry{}
+	}
+	return entries
+}
+
+// updateToolChecksumCache takes an exclusive lock on the tool checksum cache
+// 'f' and adds 'newEntries' to it. The cache is read again once locked,
+// since other processes may have updated it in the meantime
+func updateToolChecksumCache(
+	f *os.File,
+	newEntries map[string]toolChecksumCacheEntry,
+) error {
+	err := lockFile(f)
+	if err != nil {
+		return errors.Wrapf(err, "while locking %s", f.Name())
+	}
+	entries := readToolChecksumCache(f)
+	for path, entry := range newEntries {
+		entries[path] = entry
+	}
+	err = f.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(f).Encode(entries)
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/afjoseph/conjunct/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckToolChecksums(t *testing.T) {
+	dir := t.TempDir()
+	toolPath := filepath.Join(dir, "opt")
+	require.NoError(t, os.WriteFile(toolPath, []byte("hello\n"), 0755))
+	// sha256 of "hello\n"
+	helloChecksum := "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+	cachePath := filepath.Join(dir, "cache", "tool-checksums.json")
+	cfg := &config.Config{
+		ToolChecksums:         map[string]string{toolPath: helloChecksum},
+		ToolChecksumCachePath: cachePath,
+	}
+
+	// Matching tool: its checksum is cached
+	require.NoError(t, checkToolChecksums(cfg))
+	b, err := os.ReadFile(cachePath)
+	require.NoError(t, err)
+	entries := map[string]toolChecksumCacheEntry{}
+	require.NoError(t, json.Unmarshal(b, &entries))
+	require.Equal(t, helloChecksum, entries[toolPath].SHA256)
+
+	// The cached checksum is used as long as the mtime and size match
+	entries[toolPath] = toolChecksumCacheEntry{
+		ModTime: entries[toolPath].ModTime,
+		Size:    entries[toolPath].Size,
+		SHA256:  "bad",
+	}
+	b, err = json.Marshal(entries)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(cachePath, b, 0644))
+	err = checkToolChecksums(cfg)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), toolPath+" has checksum bad")
+
+	// A swapped tool is hashed again and refused
+	require.NoError(t, os.WriteFile(toolPath, []byte("swapped\n"), 0755))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(toolPath, later, later))
+	err = checkToolChecksums(cfg)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "refusing to run")
+	require.Contains(t, err.Error(), ", not "+helloChecksum)
+
+	// Missing tool
+	cfg.ToolChecksums = map[string]string{
+		filepath.Join(dir, "missing"): helloChecksum,
+	}
+	require.Error(t, checkToolChecksums(cfg))
+}
+
+func TestUpdateToolChecksumCache(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "tool-checksums.json")
+	f, entries, err := openToolChecksumCache(cachePath)
+	require.NoError(t, err)
+	defer f.Close()
+	require.Empty(t, entries)
+
+	// Entries written by other processes since the cache was read are kept
+	require.NoError(t, os.WriteFile(cachePath, []byte(`{"/bin/clang":{"sha256":"aa"}}`), 0644))
+	err = updateToolChecksumCache(f, map[string]toolChecksumCacheEntry{
+		"/bin/opt": {SHA256: "bb"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, unlockFile(f))
+	b, err := os.ReadFile(cachePath)
+	require.NoError(t, err)
+	entries = map[string]toolChecksumCacheEntry{}
+	require.NoError(t, json.Unmarshal(b, &entries))
+	require.Equal(t, map[string]toolChecksumCacheEntry{
+		"/bin/clang": {SHA256: "aa"},
+		"/bin/opt":   {SHA256: "bb"},
+	}, entries)
+}
@@ -1,26 +1,35 @@
 package core
 
 import (
+	stderr "errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/afjoseph/conjunct/argsparser"
+	"github.com/afjoseph/conjunct/cache"
 	"github.com/afjoseph/conjunct/config"
+	"github.com/afjoseph/conjunct/invocation"
 	"github.com/afjoseph/conjunct/sourcefile"
+	"github.com/afjoseph/conjunct/universal"
 	"github.com/afjoseph/conjunct/util"
 	"github.com/go-playground/errors/v5"
 	"github.com/sirupsen/logrus"
 )
 
 // emitBitcode emits bitcode for 'objectName' using 'clangPath' and
-// 'originalArgs'
+// 'originalArgs'. Debug info flags are stripped unless 'preserveDebugInfo'
+// is true. See newClangCommand() for 'useResponseFile'
 func emitBitcode(
 	objectName string,
 	clangPath string,
 	originalArgs []string,
 	tempDir string,
+	preserveDebugInfo bool,
+	useResponseFile bool,
+	limits config.Limits,
 	isDryRun bool,
 ) (bitcodeFilepath string, err error) {
 	logrus.Debugln("emitBitcode()")
@@ -35,13 +44,19 @@ func emitBitcode(
 	}
 
 	args := append([]string(nil), originalArgs...) // Copies the slice
-	// These cause errors when supplied to opt later
-	args = argsparser.RemoveArg(args, "-g", false)
-	args = argsparser.RemoveArg(args, "-gmodules", false)
+	if preserveDebugInfo {
+		args = rewriteDebugInfoArgs(args)
+	} else {
+		// These cause errors when supplied to opt later
+		args = argsparser.RemoveArg(args, "-g", false)
+		args = argsparser.RemoveArg(args, "-gmodules", false)
+	}
 	// -fembed-bitcode usually included twice
 	args = argsparser.RemoveArg(args, "-fembed-bitcode", false)
 	args = argsparser.RemoveArg(args, "-fembed-bitcode", false)
 	args = argsparser.RemoveArg(args, "-fembed-bitcode-marker", false)
+	// Depfile arguments are kept: they were made explicit so that the depfile
+	// is the one of the real output. See makeDepfileArgsExplicit()
 	args = argsparser.RemoveArg(args, "-o", true)
 	// XXX We don't want our passes to play with address sanitizer (ASAN) code,
 	// so it is best to remove it at this step.
@@ -50,7 +65,12 @@ func emitBitcode(
 	// are **tested** against sanitizers (but not **scheduled** with it).
 	args = argsparser.RemoveRegexArg(args, "-fsanitize=[a-z,]+")
 	args = argsparser.AddArg(args, "-emit-llvm", "")
-	// args = argsparser.AddArg(args, "-c", "")
+	// Assembly compilations (i.e., -S) emit bitcode like object compilations:
+	// buildBitcode() takes care of producing assembly
+	if argsparser.HasArg(args, "-S") {
+		args = argsparser.RemoveArg(args, "-S", false)
+		args = argsparser.AddArg(args, "-c", "")
+	}
 	args = argsparser.AddArg(args, "-o", bitcodeFilepath)
 	// XXX <02-03-2024, afjoseph> When running Conjunct with different build
 	// flags, it's wise to tell the compiler to ignore those flags, else some
@@ -62,12 +82,15 @@ func emitBitcode(
 	)
 
 	logrus.Infof("Emitting bitcode for %s", objectName)
-	cmd := exec.Command(clangPath, args...)
+	cmd, err := newClangCommand(clangPath, args, tempDir, useResponseFile)
+	if err != nil {
+		return "", err
+	}
 	logrus.Debugf("cmd: %s", cmd.String())
 	if isDryRun {
 		logrus.Debugln("Dry-run: not running above command")
 	} else {
-		ret, err := cmd.CombinedOutput()
+		ret, err := runWithLimits(cmd, "emit", limits)
 		if err != nil {
 			return "", errors.Wrapf(err, "while emitting bitcode: %s", string(ret))
 		}
@@ -76,22 +99,30 @@ func emitBitcode(
 	return bitcodeFilepath, nil
 }
 
-// schedulePasses runs opt on 'inputFilepath' using information from 'cfg'.
+// schedulePasses runs opt on 'inputFilepath' using information from 'stage'.
+// 'stageIdx' is the index of 'stage' in the pipeline. The placeholders of
+// 'vars' are replaced in the args of 'stage' (see stageVars)
 func schedulePasses(
 	objectName string,
-	optPath string,
-	optCLIArgs []string,
-	optEnvVars map[string]string,
+	stageIdx int,
+	stage config.Stage,
 	inputFilepath string,
 	tempDir string,
+	vars stageVars,
+	limits config.Limits,
 	isDryRun bool,
 ) (outputFilepath string, err error) {
-	logrus.Debugf("SchedulePasses() on %s at %s", objectName, inputFilepath)
+	stageName := getStageName(stage, stageIdx)
+	logrus.Debugf(
+		"SchedulePasses() on %s at %s for stage %s",
+		objectName,
+		inputFilepath,
+		stageName,
+	)
 
 	// Create temporary file to be the output of the opt command
-	baseName := util.GetBasenameWithoutExtension(inputFilepath)
 	outputFile, err := os.Create(
-		filepath.Join(tempDir, fmt.Sprintf("%s.opt.bc", baseName)),
+		filepath.Join(tempDir, fmt.Sprintf("%s.%s.opt.bc", objectName, stageName)),
 	)
 	if err != nil {
 		return "", errors.Wrapf(err, "while creating temp file")
@@ -102,37 +133,303 @@ func schedulePasses(
 	}
 
 	cliArgs := []string{}
-	for _, arg := range optCLIArgs {
-		cliArgs = append(cliArgs, arg)
+	for _, arg := range stage.GetOptCLIArgs() {
+		cliArgs = append(cliArgs, vars.interpolate(arg))
 	}
 	cliArgs = append(cliArgs, inputFilepath)
 	cliArgs = append(cliArgs, "-o", outputFilepath)
 
-	cmd := exec.Command(optPath, cliArgs...)
-	cmd.Env = os.Environ()
-	for k, v := range optEnvVars {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
-	}
+	cmd := exec.Command(stage.OptPath, cliArgs...)
+	cmd.Env = vars.environ(os.Environ(), stage.OptEnvVars)
 	logrus.Debugf(
 		"Running opt on %s @ %s -> %s using this command: %s and these env vars: %+v",
 		objectName,
 		inputFilepath,
 		outputFilepath,
 		cmd.String(),
-		optEnvVars,
+		stage.OptEnvVars,
 	)
 	if isDryRun {
 		logrus.Debugln("Dry-run: not running above command")
 	} else {
-		b, err := cmd.CombinedOutput()
+		b, err := runWithLimits(cmd, stageName, limits)
 		if err != nil {
 			return "", errors.Wrapf(err, "while running opt: %s", string(b))
 		}
-		logrus.Infof("Opt ran successfully: %s", string(b))
+		logrus.Infof("Opt ran successfully for stage %s: %s", stageName, string(b))
+	}
+	return outputFilepath, nil
+}
+
+// runCommandStage runs the command template of 'stage' on 'inputFilepath'.
+// See config.Stage.Command for the supported placeholders.
+//
+// The command must write its output to the '{output}' placeholder: it is an
+// error if the output file is missing or empty after the command ran.
+func runCommandStage(
+	objectName string,
+	stageIdx int,
+	stage config.Stage,
+	sourceFilepath string,
+	inputFilepath string,
+	tempDir string,
+	vars stageVars,
+	limits config.Limits,
+	isDryRun bool,
+) (outputFilepath string, err error) {
+	stageName := getStageName(stage, stageIdx)
+	logrus.Debugf(
+		"runCommandStage() on %s at %s for stage %s",
+		objectName,
+		inputFilepath,
+		stageName,
+	)
+
+	// Don't create the output file here: we need to know if the command
+	// actually wrote it
+	outputFilepath = filepath.Join(
+		tempDir,
+		fmt.Sprintf("%s.%s.bc", objectName, stageName),
+	)
+	replacer := strings.NewReplacer(append(
+		[]string{
+			"{input}", inputFilepath,
+			"{output}", outputFilepath,
+			"{tempdir}", tempDir,
+			"{source}", sourceFilepath,
+		},
+		vars.placeholders()...,
+	)...)
+	cmdArgs := []string{}
+	for _, arg := range stage.Command {
+		cmdArgs = append(cmdArgs, replacer.Replace(arg))
+	}
+
+	cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
+	cmd.Env = vars.environ(os.Environ(), stage.EnvVars)
+	logrus.Debugf(
+		"Running stage %s on %s @ %s -> %s using this command: %s and these env vars: %+v",
+		stageName,
+		objectName,
+		inputFilepath,
+		outputFilepath,
+		cmd.String(),
+		stage.EnvVars,
+	)
+	if isDryRun {
+		logrus.Debugln("Dry-run: not running above command")
+		return outputFilepath, nil
+	}
+	b, err := runWithLimits(cmd, stageName, limits)
+	if err != nil {
+		return "", errors.Wrapf(err, "while running command: %s", string(b))
+	}
+	info, err := os.Stat(outputFilepath)
+	if err != nil {
+		return "", errors.Wrapf(
+			err,
+			"stage %s didn't write its output file %s",
+			stageName,
+			outputFilepath,
+		)
+	}
+	if info.Size() == 0 {
+		return "", errors.Newf(
+			"stage %s wrote an empty output file %s",
+			stageName,
+			outputFilepath,
+		)
+	}
+	logrus.Infof("Stage %s ran successfully: %s", stageName, string(b))
+	return outputFilepath, nil
+}
+
+// runStages runs every stage in 'stages' sequentially on 'bitcodeFilepath',
+// feeding the output of each stage to the next one. Returns the output of
+// the last stage.
+//
+// Failed stages are handled according to their failure policy in 'cfg'.
+// 'skippedStages' is true if a stage failed and was skipped. Every stage
+// gets 'vars' (see stageVars)
+func runStages(
+	cfg *config.Config,
+	sourceFilepath string,
+	stages []config.Stage,
+	bitcodeFilepath string,
+	tempDir string,
+	vars stageVars,
+	isDryRun bool,
+) (outputFilepath string, skippedStages bool, err error) {
+	sourceFileName := filepath.Base(sourceFilepath)
+	outputFilepath = bitcodeFilepath
+	for i, stage := range stages {
+		prevOutputFilepath := outputFilepath
+		switch stage.Kind() {
+		case config.StageKind_Command:
+			outputFilepath, err = runCommandStage(
+				sourceFileName,
+				i,
+				stage,
+				sourceFilepath,
+				outputFilepath,
+				tempDir,
+				vars,
+				cfg.GetStageLimits(&stage),
+				isDryRun,
+			)
+		default:
+			outputFilepath, err = schedulePasses(
+				sourceFileName,
+				i,
+				stage,
+				outputFilepath,
+				tempDir,
+				vars,
+				cfg.GetStageLimits(&stage),
+				isDryRun,
+			)
+		}
+		stageName := getStageName(stage, i)
+		if err == nil && cfg.VerifyStages && !isDryRun {
+			verifyOptPath := stage.OptPath
+			if stage.Kind() == config.StageKind_Command {
+				verifyOptPath = cfg.OptPath
+			}
+			err = verifyBitcode(
+				verifyOptPath,
+				outputFilepath,
+				stageName,
+				cfg.GetStageLimits(&stage),
+			)
+		}
+		if err == nil {
+			if cfg.PreserveDebugInfo && !isDryRun {
+				warnIfStageDropsDebugInfo(
+					stageName,
+					prevOutputFilepath,
+					outputFilepath,
+				)
+			}
+			continue
+		}
+		switch cfg.GetFailurePolicy(&stage) {
+		case config.FailurePolicy_Warn:
+			logrus.Warnf(
+				"Stage %s failed on %s: skipping it: %v",
+				stageName,
+				sourceFilepath,
+				err,
+			)
+			outputFilepath = prevOutputFilepath
+			skippedStages = true
+		case config.FailurePolicy_Fallback:
+			return "", false, &FallbackError{Step: stageName, Err: err}
+		default:
+			return "", false, errors.Wrapf(
+				err,
+				"while scheduling passes for stage %s",
+				stageName,
+			)
+		}
+	}
+	return outputFilepath, skippedStages, nil
+}
+
+// runCachedStages is runStages() with the on-disk cache configured in 'cfg'.
+// On a cache hit, no stage runs. Cache failures are logged and never fail
+// the build.
+func runCachedStages(
+	cfg *config.Config,
+	sourceFilepath string,
+	stages []config.Stage,
+	bitcodeFilepath string,
+	tempDir string,
+	vars stageVars,
+	isDryRun bool,
+) (outputFilepath string, err error) {
+	bitcodeCache, cacheKey := openCache(
+		cfg,
+		stages,
+		bitcodeFilepath,
+		vars,
+		isDryRun,
+	)
+	if bitcodeCache != nil {
+		cachedFilepath := filepath.Join(
+			tempDir,
+			filepath.Base(sourceFilepath)+".cached.bc",
+		)
+		hit, err := bitcodeCache.Get(cacheKey, cachedFilepath)
+		if err != nil {
+			logrus.Warnf("Failed to read from cache: %v", err)
+		}
+		if hit {
+			logrus.Infof("Cache hit for %s: skipping stages", sourceFilepath)
+			return cachedFilepath, nil
+		}
+		logrus.Debugf("Cache miss for %s with key %s", sourceFilepath, cacheKey)
+	}
+
+	outputFilepath, skippedStages, err := runStages(
+		cfg,
+		sourceFilepath,
+		stages,
+		bitcodeFilepath,
+		tempDir,
+		vars,
+		isDryRun,
+	)
+	if err != nil {
+		return "", err
+	}
+	// Don't cache the output of a partially-run pipeline
+	if bitcodeCache == nil || skippedStages {
+		return outputFilepath, nil
+	}
+	if err := bitcodeCache.Put(cacheKey, outputFilepath); err != nil {
+		logrus.Warnf("Failed to write to cache: %v", err)
 	}
 	return outputFilepath, nil
 }
 
+// openCache returns the cache configured in 'cfg' and the key of running
+// 'stages' on 'bitcodeFilepath' with 'vars'. Returns a nil cache if caching
+// is disabled or failed.
+func openCache(
+	cfg *config.Config,
+	stages []config.Stage,
+	bitcodeFilepath string,
+	vars stageVars,
+	isDryRun bool,
+) (*cache.Cache, string) {
+	if len(cfg.Cache.Dir) == 0 || cfg.NoCache || isDryRun || len(stages) == 0 {
+		return nil, ""
+	}
+	bitcodeCache, err := cache.New(
+		cfg.Cache.Dir,
+		cfg.Cache.MaxSizeMB*1024*1024,
+	)
+	if err != nil {
+		logrus.Warnf("Not using cache: %v", err)
+		return nil, ""
+	}
+	cacheKey, err := cache.Key(bitcodeFilepath, stages, vars.cacheKeyEnvVars())
+	if err != nil {
+		logrus.Warnf("Not using cache: %v", err)
+		return nil, ""
+	}
+	return bitcodeCache, cacheKey
+}
+
+// getStageName returns the name of 'stage' if it has one, else a name
+// derived from its index in the pipeline
+func getStageName(stage config.Stage, stageIdx int) string {
+	if len(stage.Name) != 0 {
+		return stage.Name
+	}
+	return fmt.Sprintf("stage%d", stageIdx)
+}
+
 // buildBitcode() builds an object file from the bitcode file located in
 // 'bitcodeFilepath', after modifying args from 'originalArgs' array.
 //
@@ -141,17 +438,39 @@ func schedulePasses(
 // Android and iOS build systems have a separate step where they do the
 // linking that is separate from the step where object files are built.
 // Conjunct only cares about producing modified object files, not the linking
-// step.
+// step. If 'originalArgs' has -S, you'll get an assembly file instead since
+// -S takes precedence over -c in clang.
+//
+// If 'preserveDebugInfo' is true, debug info flags that conflict with a
+// bitcode input are rewritten (see rewriteDebugInfoArgs()). See
+// newClangCommand() for 'tempDir' and 'useResponseFile'
 func buildBitcode(
 	clangPath string,
 	bitcodeFilepath string,
 	originalArgs []string,
+	tempDir string,
+	preserveDebugInfo bool,
+	useResponseFile bool,
+	limits config.Limits,
 	isDryRun bool,
 ) (string, error) {
 	args := append([]string(nil), originalArgs...) // Copies the slice
+	if preserveDebugInfo {
+		args = rewriteDebugInfoArgs(args)
+	}
+	// Replace the source file with the bitcode file. Source files are
+	// removed by index so that option values spelled like them (e.g., the
+	// value of '-Xclang -main-file-name -Xclang foo.c') are kept
+	sourceFileIndices := []int{}
+	for _, sourceFile := range sourcefile.GetSourceFiles(args) {
+		sourceFileIndices = append(sourceFileIndices, sourceFile.Index)
+	}
+	args = argsparser.RemoveArgsAt(args, sourceFileIndices)
+	// The emit step already wrote the depfile
+	args = stripDepfileArgs(args)
 	args = argsparser.RemoveArg(args, "-x", true)
 	args = argsparser.AddArg(args, "-x", "ir")
-	args = argsparser.RemoveArg(args, "-c", true)
+	args = argsparser.RemoveArg(args, "-c", false)
 	args = argsparser.AddArg(args, "-c", bitcodeFilepath)
 	// XXX <02-03-2024, afjoseph> When running Conjunct with different build
 	// flags, it's wise to tell the compiler to ignore those flags, else some
@@ -166,7 +485,10 @@ func buildBitcode(
 		return "", errors.New("missing -o argument")
 	}
 
-	cmd := exec.Command(clangPath, args...)
+	cmd, err := newClangCommand(clangPath, args, tempDir, useResponseFile)
+	if err != nil {
+		return "", err
+	}
 	logrus.Debugf(
 		"Building bitcode %s to an object file with the following args %+v",
 		bitcodeFilepath,
@@ -175,9 +497,9 @@ func buildBitcode(
 	if isDryRun {
 		logrus.Debugln("Dry-run: not running above command")
 	} else {
-		ret, err := cmd.CombinedOutput()
+		ret, err := runWithLimits(cmd, "build", limits)
 		if err != nil {
-			return "", errors.Wrapf(err, "while building bitcode: %s: %w", string(ret), err)
+			return "", errors.Wrapf(err, "while building bitcode: %s", string(ret))
 		}
 		logrus.Infof("Successfully built bitcode for %s at %s", bitcodeFilepath, outFilepath)
 	}
@@ -185,11 +507,104 @@ func buildBitcode(
 	return outFilepath, nil
 }
 
+// runPipeline emits bitcode for 'sourceFilepath', runs 'stages' on it and
+// builds the result. Failed steps are handled according to the failure
+// policies in 'cfg': a *FallbackError is returned if the source file must be
+// compiled with the original clang instead.
+//
+// If 'sourceFileType' is LLVM IR, the stages run on 'sourceFilepath' directly
+func runPipeline(
+	cfg *config.Config,
+	clangPath string,
+	args []string,
+	sourceFilepath string,
+	sourceFileType sourcefile.Type,
+	stages []config.Stage,
+	vars stageVars,
+	tempDir string,
+	isDryRun bool,
+) error {
+	bitcodeFilepath := sourceFilepath
+	if sourceFileType.IsIR() {
+		logrus.Debugf("%s is LLVM IR: not emitting bitcode", sourceFilepath)
+	} else {
+		var err error
+		bitcodeFilepath, err = emitBitcode(
+			filepath.Base(sourceFilepath),
+			clangPath,
+			args,
+			tempDir,
+			cfg.PreserveDebugInfo,
+			cfg.UseResponseFiles,
+			cfg.Limits.Emit,
+			isDryRun,
+		)
+		if err != nil {
+			return handleStepFailure(
+				cfg.GetFailurePolicy(nil),
+				"emit",
+				errors.Wrapf(err, "while emitting bitcode"),
+			)
+		}
+		if cfg.PreserveDebugInfo && !isDryRun && requestsDebugInfo(args) {
+			warnIfBitcodeLacksDebugInfo("emit", bitcodeFilepath)
+		}
+	}
+	vars = vars.withTargetTriple(args, bitcodeFilepath, isDryRun)
+	// Textual IR has no IDENTIFICATION block to check
+	isTextualIR := sourceFileType.IsIR() &&
+		sourcefile.FetchType(sourceFilepath) != sourcefile.Type_Bitcode
+	if cfg.CheckBitcodeCompat && !isDryRun && !isTextualIR {
+		err := checkBitcodeCompat(bitcodeFilepath, stages)
+		if err != nil {
+			return handleStepFailure(
+				cfg.GetFailurePolicy(nil),
+				"compat-check",
+				errors.Wrapf(err, "while checking bitcode compatibility"),
+			)
+		}
+	}
+	afterOptBitcodeFilepath, err := runCachedStages(
+		cfg,
+		sourceFilepath,
+		stages,
+		bitcodeFilepath,
+		tempDir,
+		vars,
+		isDryRun,
+	)
+	if err != nil {
+		return err
+	}
+	objectFilepath, err := buildBitcode(
+		clangPath,
+		afterOptBitcodeFilepath,
+		args,
+		tempDir,
+		cfg.PreserveDebugInfo,
+		cfg.UseResponseFiles,
+		cfg.Limits.Build,
+		isDryRun,
+	)
+	if err != nil {
+		return handleStepFailure(
+			cfg.GetFailurePolicy(nil),
+			"build",
+			errors.Wrapf(err, "while building bitcode"),
+		)
+	}
+	if cfg.PreserveDebugInfo && !isDryRun {
+		warnIfObjectLacksDebugInfo(afterOptBitcodeFilepath, objectFilepath)
+	}
+	return nil
+}
+
 // RunConjunct runs the Conjunct core using 'cfg', which looks
 // like this:
 // - Emit bitcode using emitBitcode()
-// - Run the passes using opt, sequentially, on every emitted bitcode using
-//   schedulePasses()
+// - Run every stage in the config sequentially using schedulePasses() for
+//   opt stages and runCommandStage() for command stages. The output bitcode
+//   of each stage is the input of the next one
 // - Build the modified bitcode (without linking) using buildBitcode()
 //   - To repeat: buildBitcode() does not link, so you will not get an
 //     executable: you'll get a compiled object file. This is so because both
@@ -217,10 +632,19 @@ func RunConjunct(
 	}
 	args = argsparser.RemoveArg(args, "--conjunct-dry-run", false)
 
-	// Conjunct must run only during object compilation steps.
-	// In any other instance, just run original clang
-	if !argsparser.HasArg(args, "-c") {
-		logrus.Debugln("Not an object compilation step: using Clang instead")
+	// Don't run anything, clang included, with tools that don't match their
+	// pinned checksums
+	err := checkToolChecksums(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Conjunct must run only during the compilation steps enabled in the
+	// config (object compilation by default). In any other instance, just
+	// run original clang
+	kind := invocation.Classify(args)
+	if !cfg.IsInvocationKindEnabled(kind) {
+		logrus.Debugf("Invocation kind %s is not enabled: using Clang instead", kind)
 		err, exitCode := RunClang(clangPath, args)
 		if err != nil {
 			os.Exit(exitCode)
@@ -228,55 +652,284 @@ func RunConjunct(
 		return nil
 	}
 
-	// Create temp dir
-	tempDir, err := os.MkdirTemp("", "conjunct")
-	if err != nil {
-		return errors.Wrapf(err, "while creating temp dir")
+	sourceFiles := sourcefile.GetSourceFiles(args)
+	if len(sourceFiles) == 0 {
+		return errors.New("failed to find source file name")
+	}
+	if len(sourceFiles) == 1 {
+		return runConjunctOnSource(
+			cfg,
+			clangPath,
+			args,
+			sourceFiles[0].Path,
+			sourceFiles[0].Type,
+			dryRun,
+		)
 	}
-	logrus.Debugf("Created temp dir %s", tempDir)
 
-	if !cfg.RetainTempDir {
-		defer os.RemoveAll(tempDir)
+	// Clang compiles multiple source files in one invocation (i.e.,
+	// 'clang -c a.c b.c') to one object file per source file. Run the
+	// pipeline on every source file on its own to get the same objects
+	logrus.Debugf("Found multiple source files: %+v", sourceFiles)
+	for i, sourceFile := range sourceFiles {
+		sourceArgs := getArgsForSource(args, sourceFiles, i)
+		err := runConjunctOnSource(
+			cfg,
+			clangPath,
+			sourceArgs,
+			sourceFile.Path,
+			sourceFile.Type,
+			dryRun,
+		)
+		if err != nil {
+			return errors.Wrapf(err, "while running on %s", sourceFile.Path)
+		}
 	}
+	return nil
+}
 
-	// XXX <29-09-2023, afjoseph> This is not perfectly accurate since there's
-	// no obligation by the compiler to postfix -c with the objectName, but it's
-	// what usually happens
-	sourceFileName, _ := sourcefile.GetSourceFileName(args)
-	if sourceFileName == "" {
-		return errors.Wrapf(err, "while getting source file name")
+// getArgsForSource returns the args to compile only the source file
+// 'sourceFiles[idx]' out of 'args', which compiles every source file in
+// 'sourceFiles' (see sourcefile.GetSourceFiles()). The other source files are
+// removed by index, so option values spelled like them are kept.
+//
+// Since clang doesn't accept -o with multiple source files, the output file
+// is the one clang would've produced (see invocation.GetDefaultOutputPath()).
+func getArgsForSource(
+	args []string,
+	sourceFiles []sourcefile.SourceFile,
+	idx int,
+) []string {
+	otherSourceFileIndices := []int{}
+	for i, sourceFile := range sourceFiles {
+		if i != idx {
+			otherSourceFileIndices = append(otherSourceFileIndices, sourceFile.Index)
+		}
+	}
+	sourceArgs := argsparser.RemoveArgsAt(args, otherSourceFileIndices)
+	outputFilepath := invocation.GetDefaultOutputPath(args, sourceFiles[idx].Path)
+	return argsparser.AddArg(sourceArgs, "-o", outputFilepath)
+}
+
+// runConjunctOnSource runs the Conjunct core on 'sourceFilepath', the only
+// source file compiled by 'args', of type 'sourceFileType'
+func runConjunctOnSource(
+	cfg *config.Config,
+	clangPath string,
+	args []string,
+	sourceFilepath string,
+	sourceFileType sourcefile.Type,
+	dryRun bool,
+) error {
+	if !sourceFileType.CanRunPipeline() {
+		logrus.Debugf(
+			"Can't run the pipeline on %s (%s): using Clang instead",
+			sourceFilepath,
+			sourceFileType.ClangLanguage(),
+		)
+		err, exitCode := RunClang(clangPath, args)
+		if err != nil {
+			os.Exit(exitCode)
+		}
+		return nil
+	}
+	// Apply the config's rules to the source file
+	stages, skip := cfg.GetStagesForSource(sourceFilepath)
+	if skip {
+		logrus.Debugf("Skipping %s by rule: using Clang instead", sourceFilepath)
+		err, exitCode := RunClang(clangPath, args)
+		if err != nil {
+			os.Exit(exitCode)
+		}
+		return nil
+	}
+	// Without stages (e.g., a Debug profile with no passes), there's nothing
+	// to transform: emitting and building the bitcode would only cost an
+	// extra compile and lose what clang does from source (e.g., -g)
+	if len(stages) == 0 {
+		logrus.Debugf("No stages for %s: using Clang instead", sourceFilepath)
+		err, exitCode := RunClang(clangPath, args)
+		if err != nil {
+			os.Exit(exitCode)
+		}
+		return nil
+	}
+	// Catch typos in pass names before building anything
+	if !dryRun {
+		err := config.CheckPasses(stages, cfg.GetPassCheckCachePath())
+		if err != nil {
+			return errors.Wrapf(err, "while checking passes")
+		}
 	}
 
-	bitcodeFilepath, err := emitBitcode(
-		sourceFileName,
+	// The emit step writes the depfile of the real output, if any, and the
+	// build step doesn't write one. Clang would write a compilation database
+	// fragment (i.e., -MJ) with the temporary paths of both steps, so
+	// Conjunct writes it instead
+	//
+	// Without -o, clang derives the output path from the source file. Make it
+	// explicit since every step after the emit step needs it
+	outFilepath := invocation.GetOutputPath(args, sourceFilepath)
+	if !argsparser.HasArg(args, "-o") {
+		args = argsparser.AddArg(args, "-o", outFilepath)
+	}
+	args = makeDepfileArgsExplicit(args, outFilepath)
+	compilationDatabasePath := argsparser.GetArgVal(args, "-MJ")
+	args = argsparser.RemoveArg(args, "-MJ", true)
+	vars, err := newStageVars(cfg, args, sourceFilepath, outFilepath)
+	if err != nil {
+		return err
+	}
+	err = runConjunctOnArchs(
+		cfg,
 		clangPath,
 		args,
-		tempDir,
+		sourceFilepath,
+		sourceFileType,
+		stages,
+		vars,
 		dryRun,
 	)
 	if err != nil {
-		return errors.Wrapf(err, "while emitting bitcode")
+		return err
 	}
-	afterOptBitcodeFilepath, err := schedulePasses(
-		sourceFileName,
-		cfg.OptPath,
-		cfg.OptCLIArgs,
-		cfg.OptEnvVars,
-		bitcodeFilepath,
-		tempDir,
-		dryRun,
-	)
+	if len(compilationDatabasePath) != 0 {
+		err = writeCompilationDatabaseFragment(
+			compilationDatabasePath,
+			clangPath,
+			args,
+			sourceFilepath,
+			sourceFileType,
+			outFilepath,
+		)
+		if err != nil {
+			return errors.Wrapf(err, "while writing compilation database fragment")
+		}
+	}
+	return nil
+}
+
+// runConjunctOnArchs runs 'stages' on 'sourceFilepath', the only source file
+// compiled by 'args', for every arch in 'args'
+func runConjunctOnArchs(
+	cfg *config.Config,
+	clangPath string,
+	args []string,
+	sourceFilepath string,
+	sourceFileType sourcefile.Type,
+	stages []config.Stage,
+	vars stageVars,
+	dryRun bool,
+) error {
+	archs := argsparser.GetArgVals(args, "-arch")
+	if len(archs) <= 1 {
+		return runConjunctOnArch(
+			cfg,
+			clangPath,
+			args,
+			sourceFilepath,
+			sourceFileType,
+			stages,
+			vars,
+			dryRun,
+		)
+	}
+
+	// Bitcode can't be emitted for multiple archs in one invocation: run the
+	// pipeline for every arch on its own, then merge the objects of every
+	// arch into a universal Mach-O object, like clang does
+	logrus.Debugf("Found multiple archs for %s: %+v", sourceFilepath, archs)
+	outFilepath := argsparser.GetArgVal(args, "-o")
+	if len(outFilepath) == 0 {
+		return errors.New("missing -o argument")
+	}
+	archTempDir, err := os.MkdirTemp("", "conjunct-archs")
+	if err != nil {
+		return errors.Wrapf(err, "while creating temp dir")
+	}
+	if !cfg.RetainTempDir {
+		defer os.RemoveAll(archTempDir)
+	}
+	archObjectFilepaths := []string{}
+	for _, arch := range archs {
+		archObjectFilepath := filepath.Join(
+			archTempDir,
+			fmt.Sprintf("%s.%s.o", filepath.Base(outFilepath), arch),
+		)
+		archArgs := getArgsForArch(args, arch, archObjectFilepath)
+		err := runConjunctOnArch(
+			cfg,
+			clangPath,
+			archArgs,
+			sourceFilepath,
+			sourceFileType,
+			stages,
+			vars,
+			dryRun,
+		)
+		if err != nil {
+			return errors.Wrapf(err, "while running on arch %s", arch)
+		}
+		archObjectFilepaths = append(archObjectFilepaths, archObjectFilepath)
+	}
+	err = universal.Merge(outFilepath, archObjectFilepaths)
+	if err != nil {
+		return errors.Wrapf(err, "while merging objects of archs %+v", archs)
+	}
+	logrus.Infof("Merged objects of archs %+v into %s", archs, outFilepath)
+	return nil
+}
+
+// getArgsForArch returns 'args' that only compile for 'arch' to
+// 'objectFilepath'
+func getArgsForArch(args []string, arch string, objectFilepath string) []string {
+	archArgs := append([]string(nil), args...) // Copies the slice
+	archArgs = argsparser.RemoveArg(archArgs, "-arch", true)
+	archArgs = argsparser.RemoveArg(archArgs, "-o", true)
+	archArgs = argsparser.AddArg(archArgs, "-arch", arch)
+	return argsparser.AddArg(archArgs, "-o", objectFilepath)
+}
+
+// runConjunctOnArch runs 'stages' on 'sourceFilepath', the only source file
+// compiled by 'args' for at most one arch
+func runConjunctOnArch(
+	cfg *config.Config,
+	clangPath string,
+	args []string,
+	sourceFilepath string,
+	sourceFileType sourcefile.Type,
+	stages []config.Stage,
+	vars stageVars,
+	dryRun bool,
+) error {
+	// Create temp dir
+	tempDir, err := os.MkdirTemp("", "conjunct")
 	if err != nil {
-		return errors.Wrapf(err, "while scheduling passes")
+		return errors.Wrapf(err, "while creating temp dir")
+	}
+	logrus.Debugf("Created temp dir %s", tempDir)
+
+	if !cfg.RetainTempDir {
+		defer os.RemoveAll(tempDir)
 	}
-	_, err = buildBitcode(
+
+	err = runPipeline(
+		cfg,
 		clangPath,
-		afterOptBitcodeFilepath,
 		args,
+		sourceFilepath,
+		sourceFileType,
+		stages,
+		vars.withArch(args, tempDir),
+		tempDir,
 		dryRun,
 	)
+	var fallbackErr *FallbackError
+	if stderr.As(err, &fallbackErr) {
+		return fallbackToClang(cfg, clangPath, args, sourceFilepath, fallbackErr)
+	}
 	if err != nil {
-		return errors.Wrapf(err, "while building bitcode")
+		return err
 	}
 	if dryRun {
 		// run original clang
@@ -289,16 +942,28 @@ func RunConjunct(
 			)
 		}
 	}
-	logrus.Infof("Conjunct ran successfully on %s", sourceFileName)
+	logrus.Infof("Conjunct ran successfully on %s", filepath.Base(sourceFilepath))
 	return nil
 }
 
-// RunClang runs the clang from 'clangPath' with 'args'
+// RunClang runs the clang from 'clangPath' with 'args'. If 'args' is too
+// long for the command line, it's passed through a response file
 func RunClang(
 	clangPath string,
 	args []string,
 ) (err error, exitCode int) {
 	cmd := exec.Command(clangPath, args...)
+	if needsResponseFile(args) {
+		responseFileDir, err := os.MkdirTemp("", "conjunct-")
+		if err != nil {
+			return errors.Wrapf(err, "while creating temp dir"), 1
+		}
+		defer os.RemoveAll(responseFileDir)
+		cmd, err = newClangCommand(clangPath, args, responseFileDir, true)
+		if err != nil {
+			return err, 1
+		}
+	}
 	ret, err := cmd.CombinedOutput()
 	if err != nil {
 		if exitError, ok := err.(*exec.ExitError); ok {
@@ -1,13 +1,16 @@
 package core
 
 import (
+	stderr "errors"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/afjoseph/conjunct/config"
 	"github.com/afjoseph/conjunct/projectpath"
+	"github.com/afjoseph/conjunct/sourcefile"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 )
@@ -26,6 +29,9 @@ func TestEmitBitcode(t *testing.T) {
 		clangPath,
 		[]string{"-c", testFilepath},
 		t.TempDir(),
+		false, // preserveDebugInfo
+		false, // useResponseFile
+		config.Limits{},
 		false, // isDryRun
 	)
 	require.NoError(t, err)
@@ -38,6 +44,293 @@ func TestEmitBitcode(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestSchedulePasses(t *testing.T) {
+	optPath, err := exec.LookPath("opt")
+	require.NoError(t, err)
+	llvmAsPath, err := exec.LookPath("llvm-as")
+	require.NoError(t, err)
+	tempDir := t.TempDir()
+	// Assemble a textual IR file that is readable by the opt in $PATH
+	inputPath := filepath.Join(tempDir, "hello.bc")
+	err = exec.Command(
+		llvmAsPath,
+		filepath.Join(projectpath.Root, "testassets/unit/hello.ll"),
+		"-o", inputPath,
+	).Run()
+	require.NoError(t, err)
+
+	// Run two stages, feeding the output of the first to the second
+	stages := []config.Stage{
+		{OptPath: optPath, OptCLIArgs: []string{"--lowerswitch"}},
+		{Name: "mem2reg", OptPath: optPath, OptCLIArgs: []string{"--mem2reg"}},
+	}
+	currPath := inputPath
+	for i, stage := range stages {
+		currPath, err = schedulePasses(
+			"hello.c",
+			i,
+			stage,
+			currPath,
+			tempDir,
+			stageVars{},
+			config.Limits{},
+			false, // isDryRun
+		)
+		require.NoError(t, err)
+	}
+	require.Equal(t, "hello.c.mem2reg.opt.bc", filepath.Base(currPath))
+	b, err := os.ReadFile(currPath)
+	require.NoError(t, err)
+	require.Equal(t, []byte("BC\xc0\xde"), b[:4])
+}
+
+func TestRunCommandStage(t *testing.T) {
+	llvmAsPath, err := exec.LookPath("llvm-as")
+	require.NoError(t, err)
+	_, err = exec.LookPath("llvm-dis")
+	require.NoError(t, err)
+	tempDir := t.TempDir()
+	sourcePath := filepath.Join(projectpath.Root, "testassets/unit/hello.ll")
+	inputPath := filepath.Join(tempDir, "hello.bc")
+	err = exec.Command(llvmAsPath, sourcePath, "-o", inputPath).Run()
+	require.NoError(t, err)
+
+	var testcases = []struct {
+		name          string
+		inputCommand  []string
+		expectedError string
+	}{
+		{
+			name: "Good: llvm-dis/llvm-as round trip",
+			inputCommand: []string{
+				"sh",
+				"-c",
+				"llvm-dis {input} -o {tempdir}/rt.ll && llvm-as {tempdir}/rt.ll -o {output}",
+			},
+		},
+		{
+			name: "Good: seed placeholder and env var",
+			inputCommand: []string{
+				"sh",
+				"-c",
+				`test {seed} = 42 && test "$CONJUNCT_SEED" = 42 && cp {input} {output}`,
+			},
+		},
+		{
+			name: "Good: stage var placeholders and env vars",
+			inputCommand: []string{
+				"sh",
+				"-c",
+				`test ${SOURCE_BASENAME} = hello.ll && ` +
+					`test "$CONJUNCT_OPT_LEVEL" = -O2 && ` +
+					`test "$CONJUNCT_TEMP_DIR" = {tempdir} && cp {input} {output}`,
+			},
+		},
+		{
+			name:          "Bad: command doesn't write its output",
+			inputCommand:  []string{"true", "{input}", "{output}"},
+			expectedError: "didn't write its output file",
+		},
+		{
+			name:          "Bad: command fails",
+			inputCommand:  []string{"false"},
+			expectedError: "while running command",
+		},
+	}
+	for i, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			outPath, err := runCommandStage(
+				"hello.c",
+				i,
+				config.Stage{Command: tc.inputCommand},
+				sourcePath,
+				inputPath,
+				tempDir,
+				stageVars{
+					seed:           42,
+					sourceFilepath: sourcePath,
+					optLevel:       "-O2",
+					tempDir:        tempDir,
+				},
+				config.Limits{},
+				false, // isDryRun
+			)
+			if len(tc.expectedError) != 0 {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			b, err := os.ReadFile(outPath)
+			require.NoError(t, err)
+			require.Equal(t, []byte("BC\xc0\xde"), b[:4])
+		})
+	}
+}
+
+func TestRunStagesFailurePolicy(t *testing.T) {
+	optPath, err := exec.LookPath("opt")
+	require.NoError(t, err)
+	llvmAsPath, err := exec.LookPath("llvm-as")
+	require.NoError(t, err)
+	tempDir := t.TempDir()
+	sourcePath := filepath.Join(projectpath.Root, "testassets/unit/hello.ll")
+	inputPath := filepath.Join(tempDir, "hello.bc")
+	err = exec.Command(llvmAsPath, sourcePath, "-o", inputPath).Run()
+	require.NoError(t, err)
+	goodStage := config.Stage{
+		Name:       "good",
+		OptPath:    optPath,
+		OptCLIArgs: []string{"--lowerswitch"},
+	}
+	badStage := config.Stage{
+		Name:       "bad",
+		OptPath:    optPath,
+		OptCLIArgs: []string{"--not-a-real-pass"},
+	}
+
+	var testcases = []struct {
+		name                  string
+		inputGlobalPolicy     config.FailurePolicy
+		inputStagePolicy      config.FailurePolicy
+		expectedFallback      bool
+		expectedError         bool
+		expectedSkippedStages bool
+	}{
+		{
+			name:          "Default policy fails",
+			expectedError: true,
+		},
+		{
+			name:              "Global fallback",
+			inputGlobalPolicy: config.FailurePolicy_Fallback,
+			expectedError:     true,
+			expectedFallback:  true,
+		},
+		{
+			name:                  "Stage warn overrides global fail",
+			inputGlobalPolicy:     config.FailurePolicy_Fail,
+			inputStagePolicy:      config.FailurePolicy_Warn,
+			expectedSkippedStages: true,
+		},
+		{
+			name:              "Stage fail overrides global fallback",
+			inputGlobalPolicy: config.FailurePolicy_Fallback,
+			inputStagePolicy:  config.FailurePolicy_Fail,
+			expectedError:     true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.Config{OnFailure: tc.inputGlobalPolicy}
+			stage := badStage
+			stage.OnFailure = tc.inputStagePolicy
+			outPath, skippedStages, err := runStages(
+				cfg,
+				sourcePath,
+				[]config.Stage{goodStage, stage},
+				inputPath,
+				tempDir,
+				stageVars{},
+				false, // isDryRun
+			)
+			require.Equal(t, tc.expectedSkippedStages, skippedStages)
+			if !tc.expectedError {
+				require.NoError(t, err)
+				// The bad stage is skipped: the output is the good one's
+				require.Equal(t, "hello.ll.good.opt.bc", filepath.Base(outPath))
+				return
+			}
+			require.Error(t, err)
+			var fallbackErr *FallbackError
+			require.Equal(t, tc.expectedFallback, stderr.As(err, &fallbackErr))
+			if tc.expectedFallback {
+				require.Equal(t, "bad", fallbackErr.Step)
+			}
+		})
+	}
+}
+
+func TestRecordFallback(t *testing.T) {
+	reportPath := filepath.Join(t.TempDir(), "report.jsonl")
+	cfg := &config.Config{FailureReportPath: reportPath}
+	for _, step := range []string{"emit", "my-stage"} {
+		err := recordFallback(
+			cfg,
+			"/src/hello.c",
+			&FallbackError{Step: step, Err: stderr.New("boom")},
+		)
+		require.NoError(t, err)
+	}
+	b, err := os.ReadFile(reportPath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[0], `"step":"emit"`)
+	require.Contains(t, lines[1], `"step":"my-stage"`)
+	require.Contains(t, lines[1], `"source":"/src/hello.c"`)
+}
+
+func TestCheckBitcodeCompat(t *testing.T) {
+	optPath, err := exec.LookPath("opt")
+	require.NoError(t, err)
+	optMajor, err := getOptMajorVersion(optPath)
+	require.NoError(t, err)
+	if optMajor >= 17 {
+		t.Skip("opt in $PATH can read the LLVM 17 bitcode test asset")
+	}
+	// hello.bc is produced by LLVM 17
+	err = checkBitcodeCompat(
+		filepath.Join(projectpath.Root, "testassets/unit/hello.bc"),
+		[]config.Stage{{OptPath: optPath}},
+	)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "can't be read by")
+}
+
+func TestVerifyBitcode(t *testing.T) {
+	optPath, err := exec.LookPath("opt")
+	require.NoError(t, err)
+	llvmAsPath, err := exec.LookPath("llvm-as")
+	require.NoError(t, err)
+	tempDir := t.TempDir()
+
+	var testcases = []struct {
+		name          string
+		inputIRPath   string
+		expectedError bool
+	}{
+		{
+			name:        "Valid module",
+			inputIRPath: "testassets/unit/hello.ll",
+		},
+		{
+			name:          "Broken module",
+			inputIRPath:   "testassets/unit/invalid.ll",
+			expectedError: true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			bitcodePath := filepath.Join(tempDir, filepath.Base(tc.inputIRPath)+".bc")
+			err := exec.Command(
+				llvmAsPath,
+				"-disable-verify",
+				filepath.Join(projectpath.Root, tc.inputIRPath),
+				"-o", bitcodePath,
+			).Run()
+			require.NoError(t, err)
+			err = verifyBitcode(optPath, bitcodePath, "test", config.Limits{})
+			if tc.expectedError {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), "failed verification")
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
 func TestBuildBitcode(t *testing.T) {
 	clangPath, err := exec.LookPath("clang")
 	require.NoError(t, err)
@@ -46,6 +339,10 @@ func TestBuildBitcode(t *testing.T) {
 		clangPath,
 		testFilepath,
 		[]string{"-o", "hello"},
+		t.TempDir(),
+		false, // preserveDebugInfo
+		false, // useResponseFile
+		config.Limits{},
 		false, // isDryRun
 	)
 	require.NoError(t, err)
@@ -121,3 +418,108 @@ func TestRunConjunct(t *testing.T) {
 	err = os.Remove(compiledObjectOutPath)
 	require.NoError(t, err)
 }
+
+func TestRunConjunctOnSourceWithoutStages(t *testing.T) {
+	dir := t.TempDir()
+	argsPath := filepath.Join(dir, "args")
+	clangPath := filepath.Join(dir, "clang")
+	err := os.WriteFile(
+		clangPath,
+		[]byte("#!/bin/sh\necho \"$@\" > "+argsPath+"\n"),
+		0755,
+	)
+	require.NoError(t, err)
+	// A selected profile without stages runs the original clang, even when
+	// the top-level config has some
+	cfg := &config.Config{
+		Seed:       1,
+		OptPath:    "/usr/bin/opt",
+		OptCLIArgs: []string{"--mem2reg"},
+		Profiles:   map[string]*config.Profile{"debug": {}},
+		Profile:    "debug",
+	}
+	args := []string{"-g", "-c", "a.c", "-o", "a.o"}
+	err = runConjunctOnSource(cfg, clangPath, args, "a.c", sourcefile.Type_C, false)
+	require.NoError(t, err)
+	b, err := os.ReadFile(argsPath)
+	require.NoError(t, err)
+	require.Equal(t, "-g -c a.c -o a.o\n", string(b))
+}
+
+func TestGetArgsForSource(t *testing.T) {
+	args := []string{"-O2", "-c", "a.c", "dir/b.c", "-Wall"}
+	sourceFiles := sourcefile.GetSourceFiles(args)
+	require.Equal(
+		t,
+		[]string{"-O2", "-c", "a.c", "-Wall", "-o", "a.o"},
+		getArgsForSource(args, sourceFiles, 0),
+	)
+	require.Equal(
+		t,
+		[]string{"-O2", "-c", "dir/b.c", "-Wall", "-o", "b.o"},
+		getArgsForSource(args, sourceFiles, 1),
+	)
+	// Assembly is written to a '.s' file
+	args = []string{"-S", "a.c", "dir/b.c"}
+	require.Equal(
+		t,
+		[]string{"-S", "dir/b.c", "-o", "b.s"},
+		getArgsForSource(args, sourcefile.GetSourceFiles(args), 1),
+	)
+	// Option values spelled like a source file are kept
+	args = []string{"-c", "a.c", "b.c", "-Xclang", "-main-file-name", "-Xclang", "a.c"}
+	require.Equal(
+		t,
+		[]string{"-c", "b.c", "-Xclang", "-main-file-name", "-Xclang", "a.c", "-o", "b.o"},
+		getArgsForSource(args, sourcefile.GetSourceFiles(args), 1),
+	)
+}
+
+func TestRunConjunctMultipleSources(t *testing.T) {
+	clangPath, err := exec.LookPath("clang")
+	require.NoError(t, err)
+	optPath, err := exec.LookPath("opt")
+	require.NoError(t, err)
+	// Object files are written in the working directory
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(wd)
+
+	helloPath := filepath.Join(projectpath.Root, "testassets/unit/hello.c")
+	otherPath := filepath.Join(t.TempDir(), "other.c")
+	err = os.WriteFile(otherPath, []byte("int other(void) { return 1; }\n"), 0644)
+	require.NoError(t, err)
+	config := &config.Config{
+		Seed:         123,
+		ClangDirPath: clangPath,
+		OptPath:      optPath,
+	}
+	err = RunConjunct(
+		config,
+		clangPath,
+		[]string{"-c", helloPath, otherPath},
+	)
+	require.NoError(t, err)
+	for _, objectPath := range []string{"hello.o", "other.o"} {
+		info, err := os.Stat(objectPath)
+		require.NoError(t, err)
+		require.NotZero(t, info.Size())
+	}
+}
+
+func TestGetArgsForArch(t *testing.T) {
+	args := []string{
+		"-arch", "arm64",
+		"-c", "hello.c",
+		"-arch", "x86_64",
+		"-o", "hello.o",
+	}
+	require.Equal(
+		t,
+		[]string{"-c", "hello.c", "-arch", "x86_64", "-o", "/tmp/hello.o.x86_64.o"},
+		getArgsForArch(args, "x86_64", "/tmp/hello.o.x86_64.o"),
+	)
+	// 'args' is not modified
+	require.Equal(t, "arm64", args[1])
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"regexp"
+
+	"github.com/afjoseph/conjunct/argsparser"
+	"github.com/afjoseph/conjunct/bitcode"
+	"github.com/sirupsen/logrus"
+)
+
+// debugInfoArgRegex matches the clang flags that turn debug info on
+var debugInfoArgRegex = regexp.MustCompile(
+	`^-g([0-3]|full|modules|line-tables-only|line-directives-only|dwarf(-[2-5])?)?$`,
+)
+
+// requestsDebugInfo returns true if 'args' turns debug info on, i.e., the
+// last debug info flag in 'args' isn't -g0
+func requestsDebugInfo(args []string) bool {
+	isRequested := false
+	for _, arg := range args {
+		switch {
+		case arg == "-g0":
+			isRequested = false
+		case debugInfoArgRegex.MatchString(arg):
+			isRequested = true
+		}
+	}
+	return isRequested
+}
+
+// rewriteDebugInfoArgs rewrites the debug info flags in 'args' that conflict
+// with running opt on the emitted bitcode, or with building it again:
+// -gmodules makes clang reference debug info in precompiled module files
+// that don't follow the bitcode around, so it's replaced with a plain -g
+func rewriteDebugInfoArgs(args []string) []string {
+	if !argsparser.HasArg(args, "-gmodules") {
+		return args
+	}
+	hasPlainDebugFlag := argsparser.HasArg(args, "-g")
+	newArgs := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg != "-gmodules" {
+			newArgs = append(newArgs, arg)
+			continue
+		}
+		if !hasPlainDebugFlag {
+			newArgs = append(newArgs, "-g")
+			hasPlainDebugFlag = true
+		}
+	}
+	return newArgs
+}
+
+// warnIfBitcodeLacksDebugInfo logs a warning if the bitcode file in
+// 'bitcodeFilepath', produced by 'step', has no debug info
+func warnIfBitcodeLacksDebugInfo(step, bitcodeFilepath string) {
+	hasDebugInfo, err := bitcode.HasDebugInfo(bitcodeFilepath)
+	if err != nil {
+		logrus.Warnf("Couldn't check debug info after step %s: %v", step, err)
+		return
+	}
+	if !hasDebugInfo {
+		logrus.Warnf(
+			"Step %s produced %s without debug info",
+			step,
+			bitcodeFilepath,
+		)
+	}
+}
+
+// warnIfStageDropsDebugInfo logs a warning if 'inputFilepath' of stage
+// 'stageName' has debug info but its 'outputFilepath' doesn't
+func warnIfStageDropsDebugInfo(stageName, inputFilepath, outputFilepath string) {
+	if inputFilepath == outputFilepath {
+		return
+	}
+	hadDebugInfo, err := bitcode.HasDebugInfo(inputFilepath)
+	if err != nil {
+		logrus.Warnf("Couldn't check debug info before stage %s: %v", stageName, err)
+		return
+	}
+	if !hadDebugInfo {
+		return
+	}
+	warnIfBitcodeLacksDebugInfo(stageName, outputFilepath)
+}
+
+// warnIfObjectLacksDebugInfo logs a warning if the bitcode in
+// 'bitcodeFilepath' has debug info but the object file it was built to in
+// 'objectFilepath' doesn't
+func warnIfObjectLacksDebugInfo(bitcodeFilepath, objectFilepath string) {
+	hadDebugInfo, err := bitcode.HasDebugInfo(bitcodeFilepath)
+	if err != nil || !hadDebugInfo {
+		return
+	}
+	hasDebugInfo, err := objectHasDebugInfo(objectFilepath)
+	if err != nil {
+		logrus.Warnf("Couldn't check debug info of %s: %v", objectFilepath, err)
+		return
+	}
+	if !hasDebugInfo {
+		logrus.Warnf(
+			"Step build produced %s without debug info",
+			objectFilepath,
+		)
+	}
+}
+
+// objectHasDebugInfo returns true if the Mach-O or ELF object file in
+// 'path' has a DWARF debug info section.
+//
+// If the format isn't recognized, it returns true so that no false
+// warning is logged
+func objectHasDebugInfo(path string) (bool, error) {
+	if machoFile, err := macho.Open(path); err == nil {
+		defer machoFile.Close()
+		return machoFile.Section("__debug_info") != nil, nil
+	}
+	if elfFile, err := elf.Open(path); err == nil {
+		defer elfFile.Close()
+		return elfFile.Section(".debug_info") != nil, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/afjoseph/conjunct/projectpath"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestsDebugInfo(t *testing.T) {
+	var testcases = []struct {
+		name           string
+		inputArgs      []string
+		expectedRetval bool
+	}{
+		{
+			name:           "No debug flags",
+			inputArgs:      []string{"-c", "hello.c", "-o", "hello.o"},
+			expectedRetval: false,
+		},
+		{
+			name:           "Plain -g",
+			inputArgs:      []string{"-c", "hello.c", "-g"},
+			expectedRetval: true,
+		},
+		{
+			name:           "DWARF version",
+			inputArgs:      []string{"-c", "hello.c", "-gdwarf-4"},
+			expectedRetval: true,
+		},
+		{
+			name:           "-g0 after -g turns it off",
+			inputArgs:      []string{"-g", "-c", "hello.c", "-g0"},
+			expectedRetval: false,
+		},
+		{
+			name:           "-gcc-toolchain isn't a debug flag",
+			inputArgs:      []string{"-gcc-toolchain", "/usr", "-c", "hello.c"},
+			expectedRetval: false,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expectedRetval, requestsDebugInfo(tc.inputArgs))
+		})
+	}
+}
+
+func TestRewriteDebugInfoArgs(t *testing.T) {
+	var testcases = []struct {
+		name         string
+		inputArgs    []string
+		expectedArgs []string
+	}{
+		{
+			name:         "Nothing to rewrite",
+			inputArgs:    []string{"-g", "-c", "hello.c"},
+			expectedArgs: []string{"-g", "-c", "hello.c"},
+		},
+		{
+			name:         "-gmodules becomes -g",
+			inputArgs:    []string{"-gmodules", "-c", "hello.c"},
+			expectedArgs: []string{"-g", "-c", "hello.c"},
+		},
+		{
+			name:         "-gmodules is dropped if -g is there",
+			inputArgs:    []string{"-g", "-c", "hello.c", "-gmodules"},
+			expectedArgs: []string{"-g", "-c", "hello.c"},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expectedArgs, rewriteDebugInfoArgs(tc.inputArgs))
+		})
+	}
+}
+
+func TestObjectHasDebugInfo(t *testing.T) {
+	llcPath, err := exec.LookPath("llc")
+	require.NoError(t, err)
+	optPath, err := exec.LookPath("opt")
+	require.NoError(t, err)
+	tempDir := t.TempDir()
+	debugIRPath := filepath.Join(projectpath.Root, "testassets/unit/hello_debug.ll")
+
+	// Build one object with debug info and one without it
+	withDebugInfoPath := filepath.Join(tempDir, "with.o")
+	out, err := exec.Command(
+		llcPath,
+		"-mtriple=x86_64-linux-gnu",
+		"-filetype=obj",
+		debugIRPath,
+		"-o", withDebugInfoPath,
+	).CombinedOutput()
+	require.NoError(t, err, string(out))
+	strippedPath := filepath.Join(tempDir, "stripped.bc")
+	out, err = exec.Command(
+		optPath,
+		"-strip-debug",
+		debugIRPath,
+		"-o", strippedPath,
+	).CombinedOutput()
+	require.NoError(t, err, string(out))
+	withoutDebugInfoPath := filepath.Join(tempDir, "without.o")
+	out, err = exec.Command(
+		llcPath,
+		"-mtriple=x86_64-linux-gnu",
+		"-filetype=obj",
+		strippedPath,
+		"-o", withoutDebugInfoPath,
+	).CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	hasDebugInfo, err := objectHasDebugInfo(withDebugInfoPath)
+	require.NoError(t, err)
+	require.True(t, hasDebugInfo)
+	hasDebugInfo, err = objectHasDebugInfo(withoutDebugInfoPath)
+	require.NoError(t, err)
+	require.False(t, hasDebugInfo)
+}
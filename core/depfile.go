@@ -0,0 +1,143 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/afjoseph/conjunct/argsparser"
+	"github.com/afjoseph/conjunct/sourcefile"
+	"github.com/go-playground/errors/v5"
+)
+
+// depfileArgNames are the arguments that make clang write a dependency file
+// (i.e., a depfile) while compiling. The ones that take a value are removed
+// with it
+var depfileArgNames = []string{
+	"-MD", "-MMD", "-MF", "-MT", "-MQ", "-MP", "-MG", "-MV",
+}
+
+// makeDepfileArgsExplicit adds the depfile arguments clang would otherwise
+// derive from the output path, so that the depfile is the one of
+// 'outputFilepath' even when the emit step outputs somewhere else:
+//   - -MF defaults to 'outputFilepath' with a '.d' extension
+//   - The target of the depfile (i.e., -MT or -MQ) defaults to
+//     'outputFilepath'. -MQ is used since clang quotes the default target
+//
+// 'args' is returned as is if it doesn't write a depfile
+func makeDepfileArgsExplicit(args []string, outputFilepath string) []string {
+	if len(outputFilepath) == 0 ||
+		(!argsparser.HasArg(args, "-MD") && !argsparser.HasArg(args, "-MMD")) {
+		return args
+	}
+	args = append([]string(nil), args...) // Copies the slice
+	if !argsparser.HasArg(args, "-MF") {
+		depfilePath := strings.TrimSuffix(
+			outputFilepath,
+			filepath.Ext(outputFilepath),
+		) + ".d"
+		args = argsparser.AddArg(args, "-MF", depfilePath)
+	}
+	if !argsparser.HasArg(args, "-MT") && !argsparser.HasArg(args, "-MQ") {
+		args = argsparser.AddArg(args, "-MQ", outputFilepath)
+	}
+	return args
+}
+
+// stripDepfileArgs removes every depfile argument from 'args'. See
+// depfileArgNames
+func stripDepfileArgs(args []string) []string {
+	for _, argName := range depfileArgNames {
+		args = argsparser.RemoveArg(args, argName, true)
+	}
+	return args
+}
+
+// compilationDatabaseEntry is the compilation database fragment clang writes
+// for -MJ. See Clang::DumpCompilationDatabase() in clang's
+// lib/Driver/ToolChains/Clang.cpp
+type compilationDatabaseEntry struct {
+	Directory string   `json:"directory"`
+	File      string   `json:"file"`
+	Output    string   `json:"output"`
+	Arguments []string `json:"arguments"`
+}
+
+// writeCompilationDatabaseFragment appends the compilation database fragment
+// of compiling 'sourceFilepath', of type 'sourceFileType', to
+// 'outputFilepath' with 'clangPath' and 'args' to 'fragmentPath', like clang
+// does for -MJ.
+//
+// XXX Unlike clang, the default --target= isn't added to the arguments since
+// Conjunct doesn't know it without asking clang
+func writeCompilationDatabaseFragment(
+	fragmentPath string,
+	clangPath string,
+	args []string,
+	sourceFilepath string,
+	sourceFileType sourcefile.Type,
+	outputFilepath string,
+) error {
+	workingDir, err := os.Getwd()
+	if err != nil {
+		return errors.Wrapf(err, "while getting working dir")
+	}
+	entry := compilationDatabaseEntry{
+		Directory: workingDir,
+		File:      sourceFilepath,
+		Output:    outputFilepath,
+		Arguments: []string{clangPath},
+	}
+	language := sourceFileType.ClangLanguage()
+	if language != "" {
+		entry.Arguments = append(entry.Arguments, "-x"+language)
+	}
+	entry.Arguments = append(entry.Arguments, sourceFilepath, "-o", outputFilepath)
+	for _, parsedArg := range argsparser.Parse(args) {
+		if parsedArg.IsInput() {
+			continue
+		}
+		// The language, the output, the depfile and the compilation
+		// database are skipped, like clang does
+		if parsedArg.Option != nil {
+			name := parsedArg.Option.Name
+			if name == "-x" || name == "-o" || name == "-MJ" ||
+				isDepfileArgName(name) {
+				continue
+			}
+		}
+		entry.Arguments = append(
+			entry.Arguments,
+			args[parsedArg.Index:parsedArg.Index+parsedArg.Count]...,
+		)
+	}
+
+	fragment := &strings.Builder{}
+	encoder := json.NewEncoder(fragment)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(entry); err != nil {
+		return errors.Wrapf(err, "while encoding compilation database fragment")
+	}
+	f, err := os.OpenFile(fragmentPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "while opening %s", fragmentPath)
+	}
+	defer f.Close()
+	// Like clang, every fragment ends with a comma so that the fragments
+	// can be concatenated into a JSON array
+	_, err = f.WriteString(strings.TrimSuffix(fragment.String(), "\n") + ",\n")
+	if err != nil {
+		return errors.Wrapf(err, "while writing %s", fragmentPath)
+	}
+	return nil
+}
+
+func isDepfileArgName(name string) bool {
+	for _, argName := range depfileArgNames {
+		if argName == name {
+			return true
+		}
+	}
+	return false
+}
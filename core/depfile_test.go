@@ -0,0 +1,104 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/afjoseph/conjunct/sourcefile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakeDepfileArgsExplicit(t *testing.T) {
+	var testcases = []struct {
+		name                string
+		inputArgs           []string
+		inputOutputFilepath string
+		expectedArgs        []string
+	}{
+		{
+			name:                "No depfile",
+			inputArgs:           []string{"-c", "hello.c", "-o", "out/hello.o"},
+			inputOutputFilepath: "out/hello.o",
+			expectedArgs:        []string{"-c", "hello.c", "-o", "out/hello.o"},
+		},
+		{
+			name:                "Implicit depfile and target",
+			inputArgs:           []string{"-MD", "-c", "hello.c", "-o", "out/hello.o"},
+			inputOutputFilepath: "out/hello.o",
+			expectedArgs: []string{
+				"-MD", "-c", "hello.c", "-o", "out/hello.o",
+				"-MF", "out/hello.d", "-MQ", "out/hello.o",
+			},
+		},
+		{
+			name: "Explicit depfile and target",
+			inputArgs: []string{
+				"-MMD", "-MFdeps/hello.d", "-MT", "hello", "-c", "hello.c",
+			},
+			inputOutputFilepath: "out/hello.o",
+			expectedArgs: []string{
+				"-MMD", "-MFdeps/hello.d", "-MT", "hello", "-c", "hello.c",
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(
+				t,
+				tc.expectedArgs,
+				makeDepfileArgsExplicit(tc.inputArgs, tc.inputOutputFilepath),
+			)
+		})
+	}
+}
+
+func TestStripDepfileArgs(t *testing.T) {
+	args := stripDepfileArgs([]string{
+		"-MD", "-MP", "-MF", "hello.d", "-MQhello.o", "-c", "hello.c",
+	})
+	require.Equal(t, []string{"-c", "hello.c"}, args)
+}
+
+func TestWriteCompilationDatabaseFragment(t *testing.T) {
+	fragmentPath := filepath.Join(t.TempDir(), "hello.o.json")
+	args := []string{
+		"-x", "c", "-DFOO=<bar>", "-MD", "-MF", "hello.d", "-c", "hello.c",
+		"-o", "hello.o", "-Wl,-foo",
+	}
+	// Fragments are appended, like clang does
+	for i := 0; i < 2; i++ {
+		err := writeCompilationDatabaseFragment(
+			fragmentPath,
+			"/usr/bin/clang",
+			args,
+			"hello.c",
+			sourcefile.Type_C,
+			"hello.o",
+		)
+		require.NoError(t, err)
+	}
+	content, err := os.ReadFile(fragmentPath)
+	require.NoError(t, err)
+
+	var entries []compilationDatabaseEntry
+	err = json.Unmarshal(
+		[]byte("["+strings.TrimSuffix(string(content), ",\n")+"]"),
+		&entries,
+	)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	workingDir, err := os.Getwd()
+	require.NoError(t, err)
+	require.Equal(t, compilationDatabaseEntry{
+		Directory: workingDir,
+		File:      "hello.c",
+		Output:    "hello.o",
+		Arguments: []string{
+			"/usr/bin/clang", "-xc", "hello.c", "-o", "hello.o",
+			"-DFOO=<bar>", "-c", "-Wl,-foo",
+		},
+	}, entries[0])
+}
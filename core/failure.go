@@ -0,0 +1,115 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/afjoseph/conjunct/config"
+	"github.com/go-playground/errors/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// FallbackError is returned by a step of the pipeline that failed with
+// config.FailurePolicy_Fallback: the source file must be compiled with the
+// original clang instead
+type FallbackError struct {
+	// Step is the name of the step that failed: "emit", "build" or a stage
+	// name
+	Step string
+	Err  error
+}
+
+func (e *FallbackError) Error() string {
+	return fmt.Sprintf("step %s failed: %v", e.Step, e.Err)
+}
+
+func (e *FallbackError) Unwrap() error {
+	return e.Err
+}
+
+// fallbackRecord is a single line of Config.FailureReportPath
+type fallbackRecord struct {
+	Time   string `json:"time"`
+	Source string `json:"source"`
+	Step   string `json:"step"`
+	Error  string `json:"error"`
+}
+
+// fallbackToClang logs the failed step in 'fallbackErr', records it in the
+// failure report of 'cfg' and compiles with the original clang using 'args'
+// so that the build still produces an object file
+func fallbackToClang(
+	cfg *config.Config,
+	clangPath string,
+	args []string,
+	sourceFilepath string,
+	fallbackErr *FallbackError,
+) error {
+	logrus.Warnf(
+		"Step %s failed on %s: falling back to original clang: %v",
+		fallbackErr.Step,
+		sourceFilepath,
+		fallbackErr.Err,
+	)
+	if err := recordFallback(cfg, sourceFilepath, fallbackErr); err != nil {
+		logrus.Warnf("Failed to record fallback: %v", err)
+	}
+	err, exitCode := RunClang(clangPath, args)
+	if err != nil {
+		os.Exit(exitCode)
+	}
+	return nil
+}
+
+// recordFallback appends 'fallbackErr' as a JSON line to
+// cfg.FailureReportPath, if set
+func recordFallback(
+	cfg *config.Config,
+	sourceFilepath string,
+	fallbackErr *FallbackError,
+) error {
+	if len(cfg.FailureReportPath) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(fallbackRecord{
+		Time:   time.Now().Format(time.RFC3339),
+		Source: sourceFilepath,
+		Step:   fallbackErr.Step,
+		Error:  fallbackErr.Err.Error(),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "while marshaling fallback record")
+	}
+	// A single O_APPEND write keeps lines intact across concurrent
+	// invocations
+	f, err := os.OpenFile(
+		cfg.FailureReportPath,
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+		0644,
+	)
+	if err != nil {
+		return errors.Wrapf(err, "while opening %s", cfg.FailureReportPath)
+	}
+	defer f.Close()
+	_, err = f.Write(append(b, '\n'))
+	if err != nil {
+		return errors.Wrapf(err, "while writing %s", cfg.FailureReportPath)
+	}
+	return nil
+}
+
+// handleStepFailure applies the failure policy 'policy' to 'err', returned
+// by 'step'. Returns a *FallbackError if the source file must be compiled
+// with the original clang, else 'err'.
+func handleStepFailure(
+	policy config.FailurePolicy,
+	step string,
+	err error,
+) error {
+	if policy == config.FailurePolicy_Fail {
+		return err
+	}
+	return &FallbackError{Step: step, Err: err}
+}
@@ -0,0 +1,30 @@
+//go:build linux
+
+package core
+
+import (
+	"github.com/afjoseph/conjunct/config"
+	"github.com/go-playground/errors/v5"
+	"golang.org/x/sys/unix"
+)
+
+// applyRlimits applies the CPU and memory limits in 'limits' to the process
+// 'pid'
+func applyRlimits(pid int, limits config.Limits) error {
+	if limits.CPUSeconds > 0 {
+		rlimit := &unix.Rlimit{Cur: limits.CPUSeconds, Max: limits.CPUSeconds}
+		err := unix.Prlimit(pid, unix.RLIMIT_CPU, rlimit, nil)
+		if err != nil {
+			return errors.Wrapf(err, "while setting RLIMIT_CPU")
+		}
+	}
+	if limits.MemoryMB > 0 {
+		memoryBytes := limits.MemoryMB * 1024 * 1024
+		rlimit := &unix.Rlimit{Cur: memoryBytes, Max: memoryBytes}
+		err := unix.Prlimit(pid, unix.RLIMIT_AS, rlimit, nil)
+		if err != nil {
+			return errors.Wrapf(err, "while setting RLIMIT_AS")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package core
+
+import (
+	"github.com/afjoseph/conjunct/config"
+	"github.com/sirupsen/logrus"
+)
+
+// applyRlimits only warns: CPU and memory limits are only supported on Linux
+func applyRlimits(pid int, limits config.Limits) error {
+	if limits.CPUSeconds > 0 || limits.MemoryMB > 0 {
+		logrus.Warnln("CPU and memory limits are only supported on Linux: ignoring them")
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package core
+
+import (
+	"os"
+)
+
+// lockFile is a no-op: file locks are only supported on unix
+func lockFile(f *os.File) error { return nil }
+
+// lockFileShared is a no-op: file locks are only supported on unix
+func lockFileShared(f *os.File) error { return nil }
+
+// unlockFile is a no-op: file locks are only supported on unix
+func unlockFile(f *os.File) error { return nil }
@@ -0,0 +1,26 @@
+//go:build unix
+
+package core
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on 'f', waiting for other processes to
+// release theirs
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
+
+// lockFileShared takes a shared lock on 'f', waiting for other processes
+// to release their exclusive ones. lockFile() turns it into an exclusive
+// lock, though not atomically: 'f' must be read again after that
+func lockFileShared(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_SH)
+}
+
+// unlockFile releases the lock on 'f'
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/afjoseph/conjunct/config"
+	"github.com/go-playground/errors/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// TimeoutError is returned when a step of the pipeline runs longer than its
+// configured timeout
+type TimeoutError struct {
+	// Step is the name of the step that timed out: "emit", "build" or a
+	// stage name
+	Step    string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("step %s timed out after %s", e.Step, e.Timeout)
+}
+
+// signalGracePeriod is how long a step has to exit after a forwarded
+// SIGINT or SIGTERM before it's killed
+const signalGracePeriod = 2 * time.Second
+
+// runWithLimits runs 'cmd' for 'step' under 'limits' and returns its
+// combined output.
+//
+// If 'limits' has a timeout, 'cmd' runs in its own process group so that,
+// if it times out, every process it spawned is killed along with it. Since
+// the process group no longer gets the signals of the terminal (e.g.,
+// Ctrl-C) or of the build system, SIGINT and SIGTERM are forwarded to it
+// while it runs, and it's killed if it's still running after
+// signalGracePeriod. Without a timeout, 'cmd' stays in Conjunct's process
+// group and gets them directly.
+func runWithLimits(
+	cmd *exec.Cmd,
+	step string,
+	limits config.Limits,
+) ([]byte, error) {
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	var signals chan os.Signal
+	hasProcessGroup := limits.Timeout > 0
+	if hasProcessGroup {
+		setProcessGroup(cmd)
+		signals = make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(signals)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	// XXX The limits are applied right after the process started, so it can
+	// run briefly without them. That's fine for the tools we run: they don't
+	// do anything expensive before that
+	if err := applyRlimits(cmd.Process.Pid, limits); err != nil {
+		if hasProcessGroup {
+			killProcessGroup(cmd)
+		} else {
+			_ = cmd.Process.Kill()
+		}
+		cmd.Wait()
+		return nil, errors.Wrapf(err, "while applying resource limits")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	var timeout <-chan time.Time
+	if limits.Timeout > 0 {
+		timer := time.NewTimer(limits.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case err := <-done:
+		return out.Bytes(), err
+	case sig := <-signals:
+		logrus.Warnf("Got %s during step %s: forwarding it to %s", sig, step, cmd.String())
+		signalProcessGroup(cmd, sig)
+		select {
+		case <-done:
+		case <-time.After(signalGracePeriod):
+			logrus.Warnf(
+				"Step %s still running %s after %s: killing %s",
+				step,
+				signalGracePeriod,
+				sig,
+				cmd.String(),
+			)
+			killProcessGroup(cmd)
+			<-done
+		}
+		return out.Bytes(), errors.Newf("step %s interrupted by %s", step, sig)
+	case <-timeout:
+		logrus.Warnf(
+			"Step %s timed out after %s: killing %s",
+			step,
+			limits.Timeout,
+			cmd.String(),
+		)
+		killProcessGroup(cmd)
+		<-done
+		return out.Bytes(), &TimeoutError{Step: step, Timeout: limits.Timeout}
+	}
+}
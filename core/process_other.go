@@ -0,0 +1,29 @@
+//go:build !unix
+
+package core
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op: process groups are only supported on unix
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills 'cmd' only: process groups are only supported on
+// unix
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	_ = cmd.Process.Kill()
+}
+
+// signalProcessGroup sends 'sig' to 'cmd' only: process groups are only
+// supported on unix
+func signalProcessGroup(cmd *exec.Cmd, sig os.Signal) {
+	if cmd.Process == nil {
+		return
+	}
+	_ = cmd.Process.Signal(sig)
+}
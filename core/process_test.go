@@ -0,0 +1,72 @@
+package core
+
+import (
+	stderr "errors"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/afjoseph/conjunct/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunWithLimits(t *testing.T) {
+	var testcases = []struct {
+		name            string
+		inputCommand    []string
+		inputLimits     config.Limits
+		linuxOnly       bool
+		expectedTimeout bool
+		expectedError   bool
+		expectedOutput  string
+	}{
+		{
+			name:           "No limits",
+			inputCommand:   []string{"sh", "-c", "echo hello"},
+			expectedOutput: "hello\n",
+		},
+		{
+			name:         "Timeout kills the whole process group",
+			inputCommand: []string{"sh", "-c", "sleep 30 & sleep 30"},
+			inputLimits: config.Limits{
+				Timeout: 200 * time.Millisecond,
+			},
+			expectedTimeout: true,
+			expectedError:   true,
+		},
+		{
+			name:         "CPU limit",
+			inputCommand: []string{"sh", "-c", "while :; do :; done"},
+			inputLimits: config.Limits{
+				Timeout:    10 * time.Second,
+				CPUSeconds: 1,
+			},
+			linuxOnly:     true,
+			expectedError: true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.linuxOnly && runtime.GOOS != "linux" {
+				t.Skip("only supported on linux")
+			}
+			start := time.Now()
+			out, err := runWithLimits(
+				exec.Command(tc.inputCommand[0], tc.inputCommand[1:]...),
+				"test",
+				tc.inputLimits,
+			)
+			// None of the commands should run anywhere near 30 seconds
+			require.Less(t, time.Since(start), 5*time.Second)
+			var timeoutErr *TimeoutError
+			require.Equal(t, tc.expectedTimeout, stderr.As(err, &timeoutErr))
+			if tc.expectedError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedOutput, string(out))
+		})
+	}
+}
@@ -0,0 +1,39 @@
+//go:build unix
+
+package core
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes 'cmd' the leader of a new process group
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// killProcessGroup kills 'cmd' and every process in its process group
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	// A negative pid targets the whole process group
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// signalProcessGroup sends 'sig' to 'cmd' and every process in its process
+// group
+func signalProcessGroup(cmd *exec.Cmd, sig os.Signal) {
+	if cmd.Process == nil {
+		return
+	}
+	unixSig, ok := sig.(syscall.Signal)
+	if !ok {
+		unixSig = syscall.SIGTERM
+	}
+	_ = syscall.Kill(-cmd.Process.Pid, unixSig)
+}
@@ -0,0 +1,42 @@
+//go:build unix
+
+package core
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/afjoseph/conjunct/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunWithLimitsProcessGroup(t *testing.T) {
+	// Without a timeout, the command stays in our process group
+	cmd := exec.Command("true")
+	_, err := runWithLimits(cmd, "test", config.Limits{})
+	require.NoError(t, err)
+	require.Nil(t, cmd.SysProcAttr)
+
+	// With a timeout, it gets its own process group, and our SIGINT is
+	// forwarded to it so that it can clean up. What's left of the group is
+	// killed after the grace period
+	cmd = exec.Command(
+		"sh",
+		"-c",
+		`trap "echo cleaned up; exit 1" INT; sleep 30 & wait`,
+	)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		syscall.Kill(os.Getpid(), syscall.SIGINT)
+	}()
+	start := time.Now()
+	out, err := runWithLimits(cmd, "test", config.Limits{Timeout: 10 * time.Second})
+	require.Error(t, err)
+	require.Equal(t, "cleaned up\n", string(out))
+	require.Contains(t, err.Error(), "step test interrupted by interrupt")
+	require.True(t, cmd.SysProcAttr.Setpgid)
+	require.Less(t, time.Since(start), signalGracePeriod+3*time.Second)
+}
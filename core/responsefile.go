@@ -0,0 +1,52 @@
+package core
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/afjoseph/conjunct/argsparser"
+	"github.com/go-playground/errors/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// responseFileThreshold is the total length of the arguments of a clang
+// command above which they're passed through a response file, to stay far
+// from argv length limits
+const responseFileThreshold = 32 * 1024
+
+// needsResponseFile returns true if 'args' is too long to be passed to clang
+// on the command line. See responseFileThreshold
+func needsResponseFile(args []string) bool {
+	argsLen := 0
+	for _, arg := range args {
+		argsLen += len(arg) + 1
+	}
+	return argsLen > responseFileThreshold
+}
+
+// newClangCommand returns a command that runs 'clangPath' with 'args'.
+//
+// If 'useResponseFile' is true or 'args' is too long, 'args' is written to a
+// response file in 'dir' and the command runs 'clangPath' with "@file"
+// instead. The response file is removed along with 'dir'
+func newClangCommand(
+	clangPath string,
+	args []string,
+	dir string,
+	useResponseFile bool,
+) (*exec.Cmd, error) {
+	if !useResponseFile && !needsResponseFile(args) {
+		return exec.Command(clangPath, args...), nil
+	}
+	responseFile, err := os.CreateTemp(dir, "args-*.rsp")
+	if err != nil {
+		return nil, errors.Wrapf(err, "while creating response file")
+	}
+	responseFile.Close()
+	err = argsparser.WriteResponseFile(responseFile.Name(), args)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Debugf("Response file %s: %+v", responseFile.Name(), args)
+	return exec.Command(clangPath, "@"+responseFile.Name()), nil
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/afjoseph/conjunct/argsparser"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewClangCommand(t *testing.T) {
+	longArgs := []string{"-c", "hello.c"}
+	for i := 0; i < 1024; i++ {
+		longArgs = append(longArgs, "-I"+strings.Repeat("a", 64))
+	}
+	var testcases = []struct {
+		name                    string
+		inputArgs               []string
+		inputUseResponseFile    bool
+		expectedResponseFileArg bool
+	}{
+		{
+			name:                    "Short args",
+			inputArgs:               []string{"-c", "hello.c"},
+			expectedResponseFileArg: false,
+		},
+		{
+			name:                    "Short args from a response file",
+			inputArgs:               []string{"-c", "hello.c"},
+			inputUseResponseFile:    true,
+			expectedResponseFileArg: true,
+		},
+		{
+			name:                    "Long args",
+			inputArgs:               longArgs,
+			expectedResponseFileArg: true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd, err := newClangCommand(
+				"clang",
+				tc.inputArgs,
+				t.TempDir(),
+				tc.inputUseResponseFile,
+			)
+			require.NoError(t, err)
+			if !tc.expectedResponseFileArg {
+				require.Equal(t, tc.inputArgs, cmd.Args[1:])
+				return
+			}
+			require.Len(t, cmd.Args, 2)
+			require.True(t, strings.HasPrefix(cmd.Args[1], "@"))
+			expandedArgs, _, err := argsparser.ExpandResponseFiles(cmd.Args[1:])
+			require.NoError(t, err)
+			require.Equal(t, tc.inputArgs, expandedArgs)
+		})
+	}
+}
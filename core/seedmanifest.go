@@ -0,0 +1,293 @@
+package core
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/afjoseph/conjunct/config"
+	"github.com/go-playground/errors/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// SessionEnvVar is the environment variable with the id of the build
+// session (e.g., a CI build number). See recordSeed()
+const SessionEnvVar = "CONJUNCT_SESSION"
+
+// seedManifestHeader is the first line of a seed manifest: the seed of the
+// build session
+type seedManifestHeader struct {
+	Time        string `json:"time"`
+	SessionSeed int64  `json:"session-seed"`
+	// Session is the id of the build session, from $CONJUNCT_SESSION. Empty
+	// if it wasn't set
+	Session string `json:"session,omitempty"`
+}
+
+// seedManifestEntry is every other line of a seed manifest: the seed of a
+// translation unit. Source and Output are relative to the seed path root if
+// they're under it. See seedPath()
+type seedManifestEntry struct {
+	Time   string `json:"time"`
+	Source string `json:"source"`
+	Output string `json:"output"`
+	Seed   uint64 `json:"seed"`
+}
+
+// getTranslationUnitSeed returns the seed of compiling 'sourceFilepath' to
+// 'outputFilepath' with 'cfg' (see deriveSeed()). The seed of the session
+// is, in order of precedence:
+//   - The one in the manifest cfg.SeedReplayFrom
+//   - cfg.Seed, unless it's config.Seed_Random
+//   - The one in the manifest cfg.SeedManifestPath. If there's none, or if
+//     it's of another build session, a new random one is written to it
+//
+// If cfg.SeedManifestPath is set, the seed of the translation unit is
+// recorded in it. See recordSeed()
+func getTranslationUnitSeed(
+	cfg *config.Config,
+	sourceFilepath string,
+	outputFilepath string,
+) (seed uint64, sessionSeed int64, err error) {
+	sessionSeed = int64(cfg.Seed)
+	isRandom := cfg.Seed == config.Seed_Random
+	if len(cfg.SeedReplayFrom) != 0 {
+		header, err := readSeedManifestHeader(cfg.SeedReplayFrom)
+		if err != nil {
+			return 0, 0, err
+		}
+		logrus.Debugf(
+			"Replaying session seed %d from %s",
+			header.SessionSeed,
+			cfg.SeedReplayFrom,
+		)
+		sessionSeed = header.SessionSeed
+		isRandom = false
+	}
+	if len(cfg.SeedManifestPath) == 0 {
+		seed = deriveSeed(sessionSeed, cfg.SeedPathRoot, sourceFilepath, outputFilepath)
+		return seed, sessionSeed, nil
+	}
+	return recordSeed(
+		cfg.SeedManifestPath,
+		sessionSeed,
+		isRandom,
+		cfg.GetSeedSessionIdleTimeout(),
+		cfg.SeedPathRoot,
+		sourceFilepath,
+		outputFilepath,
+	)
+}
+
+// recordSeed records the seed of compiling 'sourceFilepath' to
+// 'outputFilepath' in the seed manifest at 'manifestPath', holding a lock
+// on it so that concurrent invocations of the same build session agree on
+// its seed. The build session is the one of $CONJUNCT_SESSION if it's set.
+// Else, a session ends once the manifest hasn't been written for
+// 'idleTimeout':
+//   - If 'isRandom' is true, the seed of the session is the one in the
+//     manifest. If the manifest has none, or if it's of another session, a
+//     random one is picked and the manifest is started over
+//   - Else, it's 'sessionSeed'. The manifest is started over if it has
+//     another one, or if it's of another session
+//
+// The paths of the translation unit are recorded relative to 'root' (see
+// seedPath()). A translation unit that's compiled again replaces its entry
+func recordSeed(
+	manifestPath string,
+	sessionSeed int64,
+	isRandom bool,
+	idleTimeout time.Duration,
+	root string,
+	sourceFilepath string,
+	outputFilepath string,
+) (seed uint64, retSessionSeed int64, err error) {
+	f, err := os.OpenFile(manifestPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "while opening %s", manifestPath)
+	}
+	defer f.Close()
+	err = lockFile(f)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "while locking %s", manifestPath)
+	}
+	defer unlockFile(f)
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "while reading %s", manifestPath)
+	}
+	header, entries, err := decodeSeedManifest(f)
+	hasHeader := err == nil
+	if err != nil && err != io.EOF {
+		logrus.Warnf("Starting seed manifest %s over: %v", manifestPath, err)
+	}
+	session := os.Getenv(SessionEnvVar)
+	isSameSession := header.Session == session
+	if len(session) == 0 && time.Since(info.ModTime()) > idleTimeout {
+		isSameSession = false
+	}
+	switch {
+	case !hasHeader:
+	case !isSameSession:
+		logrus.Debugf(
+			"Seed manifest %s is of another build session: starting it over",
+			manifestPath,
+		)
+		hasHeader = false
+	case isRandom:
+		sessionSeed = header.SessionSeed
+	case header.SessionSeed != sessionSeed:
+		logrus.Debugf(
+			"Seed manifest %s has session seed %d, not %d: starting it over",
+			manifestPath,
+			header.SessionSeed,
+			sessionSeed,
+		)
+		hasHeader = false
+	}
+	if !hasHeader {
+		if isRandom {
+			sessionSeed, err = newRandomSeed()
+			if err != nil {
+				return 0, 0, err
+			}
+			logrus.Infof("Picked random session seed %d", sessionSeed)
+		}
+		header = seedManifestHeader{
+			Time:        time.Now().Format(time.RFC3339),
+			SessionSeed: sessionSeed,
+			Session:     session,
+		}
+		entries = nil
+	}
+
+	seed = deriveSeed(sessionSeed, root, sourceFilepath, outputFilepath)
+	entry := seedManifestEntry{
+		Time:   time.Now().Format(time.RFC3339),
+		Source: seedPath(root, sourceFilepath),
+		Output: seedPath(root, outputFilepath),
+		Seed:   seed,
+	}
+	lines := []any{header}
+	for _, other := range entries {
+		if other.Source != entry.Source || other.Output != entry.Output {
+			lines = append(lines, other)
+		}
+	}
+	lines = append(lines, entry)
+	err = writeSeedManifest(f, lines)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "while writing %s", manifestPath)
+	}
+	return seed, sessionSeed, nil
+}
+
+// writeSeedManifest replaces the content of 'f' with 'lines', one JSON
+// line each
+func writeSeedManifest(f *os.File, lines []any) error {
+	err := f.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, line := range lines {
+		err = encoder.Encode(line)
+		if err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// readSeedManifestHeader returns the header of the seed manifest at
+// 'manifestPath'
+func readSeedManifestHeader(manifestPath string) (seedManifestHeader, error) {
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return seedManifestHeader{}, errors.Wrapf(err, "while opening %s", manifestPath)
+	}
+	defer f.Close()
+	header, err := decodeSeedManifestHeader(f)
+	if err != nil {
+		return seedManifestHeader{}, errors.Wrapf(
+			err,
+			"while reading seed manifest %s",
+			manifestPath,
+		)
+	}
+	return header, nil
+}
+
+// decodeSeedManifest decodes the header and the entries of 'r', a seed
+// manifest. Returns io.EOF if 'r' is empty
+func decodeSeedManifest(r io.Reader) (seedManifestHeader, []seedManifestEntry, error) {
+	br := bufio.NewReader(r)
+	header, err := decodeSeedManifestHeader(br)
+	if err != nil {
+		return seedManifestHeader{}, nil, err
+	}
+	entries := []seedManifestEntry{}
+	decoder := json.NewDecoder(br)
+	for {
+		entry := seedManifestEntry{}
+		err = decoder.Decode(&entry)
+		if err == io.EOF {
+			return header, entries, nil
+		}
+		if err != nil {
+			return seedManifestHeader{}, nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// decodeSeedManifestHeader decodes the first line of 'r', a seed manifest.
+// Returns io.EOF if 'r' is empty
+func decodeSeedManifestHeader(r io.Reader) (seedManifestHeader, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	line, err := br.ReadBytes('\n')
+	if len(line) == 0 {
+		if err == nil {
+			err = io.EOF
+		}
+		return seedManifestHeader{}, err
+	}
+	header := seedManifestHeader{}
+	err = json.Unmarshal(line, &header)
+	if err != nil {
+		return seedManifestHeader{}, err
+	}
+	if header.SessionSeed == 0 {
+		return seedManifestHeader{}, errors.New("missing session seed")
+	}
+	return header, nil
+}
+
+// newRandomSeed returns a random seed that is neither 0 nor
+// config.Seed_Random
+func newRandomSeed() (int64, error) {
+	b := make([]byte, 8)
+	for {
+		_, err := rand.Read(b)
+		if err != nil {
+			return 0, errors.Wrapf(err, "while picking a random seed")
+		}
+		seed := int64(binary.BigEndian.Uint64(b))
+		if seed != 0 && config.Seed(seed) != config.Seed_Random {
+			return seed, nil
+		}
+	}
+}
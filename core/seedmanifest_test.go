@@ -0,0 +1,144 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/afjoseph/conjunct/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetTranslationUnitSeedRandom(t *testing.T) {
+	manifestPath := filepath.Join(t.TempDir(), "seeds.jsonl")
+	cfg := &config.Config{
+		Seed:             config.Seed_Random,
+		SeedManifestPath: manifestPath,
+	}
+
+	// Concurrent invocations of the same session share its seed
+	sourceFilepaths := []string{"a.c", "b.c", "c.c", "d.c"}
+	sessionSeeds := make([]int64, len(sourceFilepaths))
+	seeds := make([]uint64, len(sourceFilepaths))
+	errs := make([]error, len(sourceFilepaths))
+	wg := sync.WaitGroup{}
+	for i, sourceFilepath := range sourceFilepaths {
+		wg.Add(1)
+		go func(i int, sourceFilepath string) {
+			defer wg.Done()
+			seeds[i], sessionSeeds[i], errs[i] = getTranslationUnitSeed(
+				cfg,
+				sourceFilepath,
+				sourceFilepath+".o",
+			)
+		}(i, sourceFilepath)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	sessionSeed := sessionSeeds[0]
+	require.NotZero(t, sessionSeed)
+	for i, sourceFilepath := range sourceFilepaths {
+		require.Equal(t, sessionSeed, sessionSeeds[i])
+		require.Equal(
+			t,
+			deriveSeed(sessionSeed, "", sourceFilepath, sourceFilepath+".o"),
+			seeds[i],
+		)
+	}
+	b, err := os.ReadFile(manifestPath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.Len(t, lines, 1+len(sourceFilepaths))
+	require.Contains(t, lines[0], `"session-seed"`)
+
+	// A later build replays the session
+	replayCfg := &config.Config{
+		Seed:             123,
+		SeedReplayFrom:   manifestPath,
+		SeedManifestPath: filepath.Join(t.TempDir(), "replay.jsonl"),
+	}
+	seed, replayedSessionSeed, err := getTranslationUnitSeed(
+		replayCfg,
+		"a.c",
+		"a.c.o",
+	)
+	require.NoError(t, err)
+	require.Equal(t, sessionSeed, replayedSessionSeed)
+	require.Equal(t, seeds[0], seed)
+}
+
+func TestGetTranslationUnitSeedFixed(t *testing.T) {
+	manifestPath := filepath.Join(t.TempDir(), "seeds.jsonl")
+	err := os.WriteFile(
+		manifestPath,
+		[]byte(`{"time":"","session-seed":1}`+"\n"),
+		0644,
+	)
+	require.NoError(t, err)
+	cfg := &config.Config{Seed: 123, SeedManifestPath: manifestPath}
+	seed, sessionSeed, err := getTranslationUnitSeed(cfg, "a.c", "a.o")
+	require.NoError(t, err)
+	require.Equal(t, int64(123), sessionSeed)
+	require.Equal(t, deriveSeed(123, "", "a.c", "a.o"), seed)
+
+	// Paths are recorded relative to the seed path root
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	cfg.SeedPathRoot = wd
+	_, _, err = getTranslationUnitSeed(cfg, "src/b.c", "/elsewhere/b.o")
+	require.NoError(t, err)
+	b, err := os.ReadFile(manifestPath)
+	require.NoError(t, err)
+	require.Contains(t, string(b), `"source":"src/b.c","output":"/elsewhere/b.o"`)
+
+	// The manifest of another session seed is started over
+	header, err := readSeedManifestHeader(manifestPath)
+	require.NoError(t, err)
+	require.Equal(t, int64(123), header.SessionSeed)
+}
+
+func TestGetTranslationUnitSeedSessions(t *testing.T) {
+	manifestPath := filepath.Join(t.TempDir(), "seeds.jsonl")
+	cfg := &config.Config{
+		Seed:             config.Seed_Random,
+		SeedManifestPath: manifestPath,
+	}
+	getSessionSeed := func() int64 {
+		_, sessionSeed, err := getTranslationUnitSeed(cfg, "a.c", "a.o")
+		require.NoError(t, err)
+		return sessionSeed
+	}
+
+	// Recompiling a translation unit replaces its entry
+	t.Setenv(SessionEnvVar, "build-1")
+	firstSessionSeed := getSessionSeed()
+	require.Equal(t, firstSessionSeed, getSessionSeed())
+	_, _, err := getTranslationUnitSeed(cfg, "b.c", "b.o")
+	require.NoError(t, err)
+	b, err := os.ReadFile(manifestPath)
+	require.NoError(t, err)
+	require.Len(t, strings.Split(strings.TrimSpace(string(b)), "\n"), 3)
+	require.Contains(t, string(b), `"session":"build-1"`)
+
+	// Another $CONJUNCT_SESSION is another build session
+	t.Setenv(SessionEnvVar, "build-2")
+	secondSessionSeed := getSessionSeed()
+	require.NotEqual(t, firstSessionSeed, secondSessionSeed)
+	b, err = os.ReadFile(manifestPath)
+	require.NoError(t, err)
+	require.Len(t, strings.Split(strings.TrimSpace(string(b)), "\n"), 2)
+
+	// Without $CONJUNCT_SESSION, a session ends once the manifest is idle
+	t.Setenv(SessionEnvVar, "")
+	thirdSessionSeed := getSessionSeed()
+	require.NotEqual(t, secondSessionSeed, thirdSessionSeed)
+	require.Equal(t, thirdSessionSeed, getSessionSeed())
+	idleTime := time.Now().Add(-2 * config.DefaultSeedSessionIdleTimeout)
+	require.NoError(t, os.Chtimes(manifestPath, idleTime, idleTime))
+	require.NotEqual(t, thirdSessionSeed, getSessionSeed())
+}
@@ -0,0 +1,258 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/afjoseph/conjunct/argsparser"
+	"github.com/afjoseph/conjunct/bitcode"
+	"github.com/afjoseph/conjunct/config"
+	"github.com/afjoseph/conjunct/invocation"
+	"github.com/go-playground/errors/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// SeedEnvVar is the environment variable with the seed of the translation
+// unit (see deriveSeed()) that every stage gets
+const SeedEnvVar = "CONJUNCT_SEED"
+
+// The variables of a translation unit that every stage gets, both as
+// "${NAME}" placeholders in its args and env vars and as $CONJUNCT_NAME
+// environment variables. See stageVars
+const (
+	StageVar_SourceFile     = "SOURCE_FILE"
+	StageVar_SourceBasename = "SOURCE_BASENAME"
+	StageVar_OutputFile     = "OUTPUT_FILE"
+	StageVar_TargetTriple   = "TARGET_TRIPLE"
+	StageVar_OptLevel       = "OPT_LEVEL"
+	StageVar_TempDir        = "TEMP_DIR"
+	StageVar_Arch           = "ARCH"
+)
+
+// stageVarEnvPrefix is the prefix of the environment variable of every
+// stage var (e.g., $CONJUNCT_SOURCE_FILE)
+const stageVarEnvPrefix = "CONJUNCT_"
+
+// stageVars are the values of a translation unit that every stage gets,
+// both as placeholders in its args (e.g., "{seed}" or "${SOURCE_FILE}") and
+// as environment variables (e.g., $CONJUNCT_SEED or $CONJUNCT_SOURCE_FILE)
+type stageVars struct {
+	// seed is the seed of the translation unit. See deriveSeed()
+	seed uint64
+	// sourceFilepath and outputFilepath are the absolute paths of the source
+	// file and the output file of the translation unit
+	sourceFilepath string
+	outputFilepath string
+	// optLevel is the optimization level of the translation unit (e.g.,
+	// "-O2"). See invocation.GetOptLevel()
+	optLevel string
+	// targetTriple is the target triple of the emitted bitcode (e.g.,
+	// "arm64-apple-ios17.0.0"). It's only known once the bitcode is
+	// emitted. See withTargetTriple()
+	targetTriple string
+	// tempDir is the temp dir of the pipeline and arch is the arch it
+	// compiles for (e.g., "arm64"). See withArch()
+	tempDir string
+	arch    string
+}
+
+// newStageVars returns the stage vars of compiling 'sourceFilepath' to
+// 'outputFilepath' with 'cfg' and 'args'
+func newStageVars(
+	cfg *config.Config,
+	args []string,
+	sourceFilepath string,
+	outputFilepath string,
+) (stageVars, error) {
+	seed, sessionSeed, err := getTranslationUnitSeed(
+		cfg,
+		sourceFilepath,
+		outputFilepath,
+	)
+	if err != nil {
+		return stageVars{}, errors.Wrapf(err, "while getting seed")
+	}
+	logrus.Debugf(
+		"Derived seed %d for %s -> %s from session seed %d",
+		seed,
+		sourceFilepath,
+		outputFilepath,
+		sessionSeed,
+	)
+	return stageVars{
+		seed:           seed,
+		sourceFilepath: normalizePath(sourceFilepath),
+		outputFilepath: normalizePath(outputFilepath),
+		optLevel:       invocation.GetOptLevel(args),
+	}, nil
+}
+
+// withArch returns 'vars' for the pipeline in 'tempDir' that compiles with
+// 'args' for at most one arch
+func (vars stageVars) withArch(args []string, tempDir string) stageVars {
+	vars.tempDir = tempDir
+	vars.arch = argsparser.GetArgVal(args, "-arch")
+	return vars
+}
+
+// withTargetTriple returns 'vars' with the target triple of
+// 'bitcodeFilepath', emitted with 'args'. If it can't be read (e.g., in a
+// dry run), the one in the -target arg is used, if any.
+//
+// Without -arch, the arch is the first component of the target triple
+// (e.g., "x86_64" for "x86_64-unknown-linux-gnu")
+func (vars stageVars) withTargetTriple(
+	args []string,
+	bitcodeFilepath string,
+	isDryRun bool,
+) stageVars {
+	triple := ""
+	if !isDryRun {
+		var err error
+		triple, err = bitcode.ReadTargetTriple(bitcodeFilepath)
+		if err != nil {
+			logrus.Debugf("Couldn't read target triple: %v", err)
+		}
+	}
+	if len(triple) == 0 {
+		triple = argsparser.GetArgVal(args, "-target")
+	}
+	vars.targetTriple = triple
+	if len(vars.arch) == 0 && len(triple) != 0 {
+		vars.arch = strings.SplitN(triple, "-", 2)[0]
+	}
+	return vars
+}
+
+// named returns the stage vars of 'vars' by name, without the seed
+func (vars stageVars) named() map[string]string {
+	sourceBasename := ""
+	if len(vars.sourceFilepath) != 0 {
+		sourceBasename = filepath.Base(vars.sourceFilepath)
+	}
+	return map[string]string{
+		StageVar_SourceFile:     vars.sourceFilepath,
+		StageVar_SourceBasename: sourceBasename,
+		StageVar_OutputFile:     vars.outputFilepath,
+		StageVar_TargetTriple:   vars.targetTriple,
+		StageVar_OptLevel:       vars.optLevel,
+		StageVar_TempDir:        vars.tempDir,
+		StageVar_Arch:           vars.arch,
+	}
+}
+
+// placeholders returns the placeholders of 'vars' and their values, as
+// strings.NewReplacer() takes them
+func (vars stageVars) placeholders() []string {
+	ret := []string{"{seed}", strconv.FormatUint(vars.seed, 10)}
+	for name, value := range vars.named() {
+		ret = append(ret, "${"+name+"}", value)
+	}
+	return ret
+}
+
+// interpolate returns 'arg' with the placeholders of 'vars' replaced
+func (vars stageVars) interpolate(arg string) string {
+	return strings.NewReplacer(vars.placeholders()...).Replace(arg)
+}
+
+// envVars returns the environment variables of 'vars'
+func (vars stageVars) envVars() map[string]string {
+	ret := map[string]string{SeedEnvVar: strconv.FormatUint(vars.seed, 10)}
+	for name, value := range vars.named() {
+		ret[stageVarEnvPrefix+name] = value
+	}
+	return ret
+}
+
+// cacheKeyEnvVars returns the environment variables of 'vars' that are part
+// of the cache key of a pipeline. The temp dir is left out since it differs
+// on every run
+func (vars stageVars) cacheKeyEnvVars() map[string]string {
+	ret := vars.envVars()
+	delete(ret, stageVarEnvPrefix+StageVar_TempDir)
+	return ret
+}
+
+// environ returns the environment of a stage: the environment of Conjunct,
+// then the environment variables of 'vars', then 'stageEnvVars', which can
+// override them. The placeholders of 'vars' are replaced in the values of
+// 'stageEnvVars'
+func (vars stageVars) environ(
+	baseEnv []string,
+	stageEnvVars map[string]string,
+) []string {
+	env := append([]string(nil), baseEnv...)
+	envVars := vars.envVars()
+	for _, k := range sortedKeys(envVars) {
+		env = append(env, fmt.Sprintf("%s=%s", k, envVars[k]))
+	}
+	for _, k := range sortedKeys(stageEnvVars) {
+		env = append(env, fmt.Sprintf("%s=%s", k, vars.interpolate(stageEnvVars[k])))
+	}
+	return env
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// deriveSeed returns the seed of the translation unit that compiles
+// 'sourceFilepath' to 'outputFilepath': the first 8 bytes of the SHA-256 of
+// 'seed' and both paths, relative to 'root' (see seedPath()).
+//
+// The same 'seed' always gives the same seed for the same translation unit,
+// so randomized passes are reproducible, while different translation units
+// get different seeds. Moving the tree under 'root' doesn't change the seeds
+func deriveSeed(
+	seed int64,
+	root string,
+	sourceFilepath string,
+	outputFilepath string,
+) uint64 {
+	h := sha256.New()
+	fmt.Fprintf(
+		h,
+		"%d\x00%s\x00%s",
+		seed,
+		seedPath(root, sourceFilepath),
+		seedPath(root, outputFilepath),
+	)
+	return binary.BigEndian.Uint64(h.Sum(nil)[:8])
+}
+
+// seedPath returns 'path' relative to 'root', with forward slashes, if it's
+// under 'root'. Else, or if 'root' is empty, it returns 'path' made absolute
+// and cleaned (see normalizePath())
+func seedPath(root string, path string) string {
+	absPath := normalizePath(path)
+	if len(root) == 0 {
+		return absPath
+	}
+	relPath, err := filepath.Rel(normalizePath(root), absPath)
+	if err != nil || relPath == ".." ||
+		strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return absPath
+	}
+	return filepath.ToSlash(relPath)
+}
+
+// normalizePath returns 'path' made absolute and cleaned, without resolving
+// symlinks
+func normalizePath(path string) string {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	return absPath
+}
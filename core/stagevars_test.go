@@ -0,0 +1,177 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeriveSeed(t *testing.T) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	seed := deriveSeed(123, "", "src/foo.c", "foo.o")
+
+	// Stable, whatever the spelling of the paths
+	require.Equal(t, seed, deriveSeed(123, "", "src/../src/foo.c", "./foo.o"))
+	require.Equal(
+		t,
+		seed,
+		deriveSeed(
+			123,
+			"",
+			filepath.Join(wd, "src/foo.c"),
+			filepath.Join(wd, "foo.o"),
+		),
+	)
+	// Different for a different seed, source file or output file
+	require.NotEqual(t, seed, deriveSeed(124, "", "src/foo.c", "foo.o"))
+	require.NotEqual(t, seed, deriveSeed(123, "", "src/bar.c", "foo.o"))
+	require.NotEqual(t, seed, deriveSeed(123, "", "src/foo.c", "bar.o"))
+
+	// Stable when the tree under the root moves
+	rootSeed := deriveSeed(123, "/a/proj", "/a/proj/src/foo.c", "/a/proj/foo.o")
+	require.Equal(
+		t,
+		rootSeed,
+		deriveSeed(123, "/b/proj/", "/b/proj/src/foo.c", "/b/proj/foo.o"),
+	)
+	require.NotEqual(
+		t,
+		rootSeed,
+		deriveSeed(123, "/b/proj", "/b/proj/src/bar.c", "/b/proj/foo.o"),
+	)
+	// Paths out of the root stay absolute
+	require.Equal(t, "src/foo.c", seedPath("/a/proj", "/a/proj/src/foo.c"))
+	require.Equal(t, "/a/other/foo.c", seedPath("/a/proj", "/a/other/foo.c"))
+	require.Equal(t, "/a/proj..c", seedPath("/a/proj", "/a/proj..c"))
+	require.Equal(t, "/a", seedPath("/a/proj", "/a"))
+}
+
+func TestStageVarsEnviron(t *testing.T) {
+	vars := stageVars{
+		seed:           42,
+		sourceFilepath: "/src/foo.c",
+		outputFilepath: "/out/foo.o",
+		optLevel:       "-O2",
+		targetTriple:   "arm64-apple-ios17.0.0",
+		tempDir:        "/tmp/conjunct123",
+		arch:           "arm64",
+	}
+	env := vars.environ(
+		[]string{"PATH=/bin"},
+		map[string]string{
+			"FOO":     "bar",
+			"REMARKS": "${TEMP_DIR}/${SOURCE_BASENAME}.yaml",
+		},
+	)
+	require.Equal(t, []string{
+		"PATH=/bin",
+		"CONJUNCT_ARCH=arm64",
+		"CONJUNCT_OPT_LEVEL=-O2",
+		"CONJUNCT_OUTPUT_FILE=/out/foo.o",
+		"CONJUNCT_SEED=42",
+		"CONJUNCT_SOURCE_BASENAME=foo.c",
+		"CONJUNCT_SOURCE_FILE=/src/foo.c",
+		"CONJUNCT_TARGET_TRIPLE=arm64-apple-ios17.0.0",
+		"CONJUNCT_TEMP_DIR=/tmp/conjunct123",
+		"FOO=bar",
+		"REMARKS=/tmp/conjunct123/foo.c.yaml",
+	}, env)
+	require.NotContains(t, vars.cacheKeyEnvVars(), "CONJUNCT_TEMP_DIR")
+}
+
+func TestStageVarsInterpolate(t *testing.T) {
+	vars := stageVars{
+		seed:           42,
+		sourceFilepath: "/src/foo.c",
+		outputFilepath: "/out/foo.o",
+		optLevel:       "-Oz",
+	}
+	vars = vars.withArch([]string{"-arch", "arm64", "-c", "foo.c"}, "/tmp/x")
+	vars = vars.withTargetTriple(
+		[]string{"-target", "arm64-apple-ios17.0.0"},
+		"",
+		true, // isDryRun
+	)
+	var testcases = []struct {
+		name        string
+		inputArg    string
+		expectedArg string
+	}{
+		{
+			name:        "Remarks path",
+			inputArg:    "--pass-remarks-output=${TEMP_DIR}/${SOURCE_BASENAME}.yaml",
+			expectedArg: "--pass-remarks-output=/tmp/x/foo.c.yaml",
+		},
+		{
+			name:        "Every var",
+			inputArg:    "{seed} ${SOURCE_FILE} ${OUTPUT_FILE} ${TARGET_TRIPLE} ${OPT_LEVEL} ${ARCH}",
+			expectedArg: "42 /src/foo.c /out/foo.o arm64-apple-ios17.0.0 -Oz arm64",
+		},
+		{
+			name:        "Unknown var is kept",
+			inputArg:    "${NOPE} $ARCH",
+			expectedArg: "${NOPE} $ARCH",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expectedArg, vars.interpolate(tc.inputArg))
+		})
+	}
+}
+
+func TestStageVarsWithTargetTriple(t *testing.T) {
+	irPath := filepath.Join(t.TempDir(), "foo.ll")
+	err := os.WriteFile(
+		irPath,
+		[]byte("target triple = \"x86_64-unknown-linux-gnu\"\n"),
+		0644,
+	)
+	require.NoError(t, err)
+
+	var testcases = []struct {
+		name           string
+		inputArgs      []string
+		inputIsDryRun  bool
+		expectedTriple string
+		expectedArch   string
+	}{
+		{
+			name:           "Triple from the IR, arch from the triple",
+			inputArgs:      []string{"-c", "foo.c"},
+			expectedTriple: "x86_64-unknown-linux-gnu",
+			expectedArch:   "x86_64",
+		},
+		{
+			name:           "Arch from -arch",
+			inputArgs:      []string{"-arch", "x86_64h", "-c", "foo.c"},
+			expectedTriple: "x86_64-unknown-linux-gnu",
+			expectedArch:   "x86_64h",
+		},
+		{
+			name:           "Dry run: triple from -target",
+			inputArgs:      []string{"--target=aarch64-linux-android21", "-c", "foo.c"},
+			inputIsDryRun:  true,
+			expectedTriple: "aarch64-linux-android21",
+			expectedArch:   "aarch64",
+		},
+		{
+			name:           "Dry run without -target",
+			inputArgs:      []string{"-c", "foo.c"},
+			inputIsDryRun:  true,
+			expectedTriple: "",
+			expectedArch:   "",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			vars := stageVars{}.withArch(tc.inputArgs, "/tmp/x")
+			vars = vars.withTargetTriple(tc.inputArgs, irPath, tc.inputIsDryRun)
+			require.Equal(t, tc.expectedTriple, vars.targetTriple)
+			require.Equal(t, tc.expectedArch, vars.arch)
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"os/exec"
+	"regexp"
+	"strconv"
+
+	"github.com/afjoseph/conjunct/bitcode"
+	"github.com/afjoseph/conjunct/config"
+	"github.com/go-playground/errors/v5"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// Matches "LLVM version 14.0.6" in the output of 'opt --version'
+	optVersionRegex = regexp.MustCompile(`LLVM version (\d+)\.`)
+	// optMajorVersions caches the LLVM major version of every opt binary
+	optMajorVersions = map[string]int{}
+)
+
+// getOptMajorVersion returns the LLVM major version of the opt binary at
+// 'optPath'
+func getOptMajorVersion(optPath string) (int, error) {
+	if major, ok := optMajorVersions[optPath]; ok {
+		return major, nil
+	}
+	b, err := exec.Command(optPath, "--version").CombinedOutput()
+	if err != nil {
+		return 0, errors.Wrapf(err, "while running %s --version: %s", optPath, string(b))
+	}
+	m := optVersionRegex.FindSubmatch(b)
+	if m == nil {
+		return 0, errors.Newf("failed to find the LLVM version of %s", optPath)
+	}
+	major, err := strconv.Atoi(string(m[1]))
+	if err != nil {
+		return 0, errors.Wrapf(err, "bad LLVM version in %s", string(b))
+	}
+	optMajorVersions[optPath] = major
+	return major, nil
+}
+
+// checkBitcodeCompat checks that every opt binary in 'stages' can read
+// 'bitcodeFilepath', based on its IDENTIFICATION block
+func checkBitcodeCompat(bitcodeFilepath string, stages []config.Stage) error {
+	ident, err := bitcode.ReadIdentification(bitcodeFilepath)
+	if err != nil {
+		return err
+	}
+	if ident == nil {
+		logrus.Warnf(
+			"%s has no IDENTIFICATION block: can't check bitcode compatibility",
+			bitcodeFilepath,
+		)
+		return nil
+	}
+	logrus.Debugf(
+		"Bitcode %s: producer %s, epoch %d",
+		bitcodeFilepath,
+		ident.Producer,
+		ident.Epoch,
+	)
+	checkedOptPaths := map[string]bool{}
+	for _, stage := range stages {
+		if stage.Kind() != config.StageKind_Opt ||
+			checkedOptPaths[stage.OptPath] {
+			continue
+		}
+		checkedOptPaths[stage.OptPath] = true
+		optMajor, err := getOptMajorVersion(stage.OptPath)
+		if err != nil {
+			return err
+		}
+		isKnown, err := ident.CheckCompatibility(optMajor)
+		if err != nil {
+			return errors.Wrapf(err, "while checking %s", stage.OptPath)
+		}
+		if !isKnown {
+			logrus.Warnf(
+				"Unknown bitcode producer %s: can't check compatibility with %s",
+				ident.Producer,
+				stage.OptPath,
+			)
+		}
+	}
+	return nil
+}
+
+// verifyBitcode runs the module verifier of the opt binary at 'optPath' on
+// 'bitcodeFilepath'. 'step' is the step that produced 'bitcodeFilepath'.
+func verifyBitcode(
+	optPath string,
+	bitcodeFilepath string,
+	step string,
+	limits config.Limits,
+) error {
+	cmd := exec.Command(
+		optPath,
+		"-passes=verify",
+		"-disable-output",
+		bitcodeFilepath,
+	)
+	logrus.Debugf("Verifying output of step %s: %s", step, cmd.String())
+	b, err := runWithLimits(cmd, step, limits)
+	if err != nil {
+		return errors.Wrapf(
+			err,
+			"output of step %s failed verification: %s",
+			step,
+			string(b),
+		)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package invocation
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/afjoseph/conjunct/argsparser"
+	"github.com/afjoseph/conjunct/sourcefile"
+	"github.com/go-playground/errors/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// Kind is what a compiler invocation does, based on its arguments
+type Kind string
+
+const (
+	// Kind_CompileToObject compiles source files to object files (e.g.,
+	// "clang -c foo.c")
+	Kind_CompileToObject Kind = "compile-to-object"
+	// Kind_CompileToAssembly compiles source files to assembly files (e.g.,
+	// "clang -S foo.c")
+	Kind_CompileToAssembly Kind = "compile-to-assembly"
+	// Kind_Preprocess only runs the preprocessor (e.g., "clang -E foo.c")
+	Kind_Preprocess Kind = "preprocess"
+	// Kind_DependencyScan only writes the dependencies of source files
+	// (e.g., "clang -M foo.c")
+	Kind_DependencyScan Kind = "dependency-scan"
+	// Kind_PrecompiledHeader builds a precompiled header (e.g.,
+	// "clang -x c-header foo.h")
+	Kind_PrecompiledHeader Kind = "precompiled-header"
+	// Kind_ModuleBuild builds a clang or C++20 module (e.g.,
+	// "clang++ --precompile foo.cppm")
+	Kind_ModuleBuild Kind = "module-build"
+	// Kind_Assemble assembles assembly files to object files (e.g.,
+	// "clang -c foo.S")
+	Kind_Assemble Kind = "assemble"
+	// Kind_Link links object files (i.e., no compilation mode flag)
+	Kind_Link Kind = "link"
+	// Kind_Other is everything else (e.g., "clang -###", "clang
+	// -fsyntax-only", "clang -c -emit-llvm" or "clang --version")
+	Kind_Other Kind = "other"
+)
+
+var (
+	allKinds = []Kind{
+		Kind_CompileToObject,
+		Kind_CompileToAssembly,
+		Kind_Preprocess,
+		Kind_DependencyScan,
+		Kind_PrecompiledHeader,
+		Kind_ModuleBuild,
+		Kind_Assemble,
+		Kind_Link,
+		Kind_Other,
+	}
+	headerFileExtensions = []string{".h", ".hh", ".hpp", ".hxx", ".h++"}
+	// moduleXclangArgs are the -Xclang arguments that build a module
+	moduleXclangArgs = []string{
+		"-emit-module",
+		"-emit-module-interface",
+		"-emit-header-unit",
+	}
+)
+
+func (kind *Kind) UnmarshalYAML(node *yaml.Node) error {
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+	for _, k := range allKinds {
+		if Kind(s) == k {
+			*kind = k
+			return nil
+		}
+	}
+	return errors.Newf("unknown invocation kind %q", s)
+}
+
+// CanRunPipeline returns true if the pipeline can run on invocations of
+// 'kind', i.e., if they compile source files to something clang can build
+// from bitcode
+func (kind Kind) CanRunPipeline() bool {
+	return kind == Kind_CompileToObject || kind == Kind_CompileToAssembly
+}
+
+// Classify returns the kind of the compiler invocation with 'args'.
+//
+// Like in clang, the earliest phase wins (e.g., "-c -E" preprocesses) and
+// -M/-MM imply -E
+func Classify(args []string) Kind {
+	switch {
+	case argsparser.HasArg(args, "-###"):
+		return Kind_Other
+	case argsparser.HasArg(args, "-M") || argsparser.HasArg(args, "-MM"):
+		return Kind_DependencyScan
+	case argsparser.HasArg(args, "-E"):
+		return Kind_Preprocess
+	case argsparser.HasArg(args, "-fsyntax-only"):
+		return Kind_Other
+	case isModuleBuild(args):
+		return Kind_ModuleBuild
+	case isPrecompiledHeader(args):
+		return Kind_PrecompiledHeader
+	}
+	isAssembly := argsparser.HasArg(args, "-S")
+	if !isAssembly && !argsparser.HasArg(args, "-c") {
+		if len(argsparser.Inputs(args)) == 0 {
+			return Kind_Other
+		}
+		return Kind_Link
+	}
+	if argsparser.HasArg(args, "-emit-llvm") {
+		return Kind_Other
+	}
+	if isAssemble(args) {
+		return Kind_Assemble
+	}
+	if isAssembly {
+		return Kind_CompileToAssembly
+	}
+	return Kind_CompileToObject
+}
+
+// isModuleBuild returns true if 'args' builds a clang or C++20 module
+func isModuleBuild(args []string) bool {
+	if argsparser.HasArg(args, "--precompile") ||
+		argsparser.HasArg(args, "-fmodule-header") {
+		return true
+	}
+	for _, xclangArg := range argsparser.GetArgVals(args, "-Xclang") {
+		for _, moduleArg := range moduleXclangArgs {
+			if xclangArg == moduleArg {
+				return true
+			}
+		}
+	}
+	for _, language := range argsparser.GetArgVals(args, "-x") {
+		if language == "c++-module" || strings.HasSuffix(language, "-header-unit-header") {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrecompiledHeader returns true if 'args' builds a precompiled header,
+// i.e., its language is a header language or, if there's no -x, its inputs
+// are headers
+func isPrecompiledHeader(args []string) bool {
+	for _, language := range argsparser.GetArgVals(args, "-x") {
+		if strings.HasSuffix(language, "-header") {
+			return true
+		}
+	}
+	return allInputsHaveExtension(args, headerFileExtensions)
+}
+
+// isAssemble returns true if 'args' assembles assembly files, i.e., every
+// source file is assembly, either from -x or from its extension
+func isAssemble(args []string) bool {
+	sourceFiles := sourcefile.GetSourceFiles(args)
+	if len(sourceFiles) == 0 {
+		return false
+	}
+	for _, sourceFile := range sourceFiles {
+		if sourceFile.Type != sourcefile.Type_Assembly &&
+			sourceFile.Type != sourcefile.Type_AssemblyWithCpp {
+			return false
+		}
+	}
+	return true
+}
+
+// allInputsHaveExtension returns true if 'args' has inputs, no -x argument
+// and every input has one of 'extensions'
+func allInputsHaveExtension(args []string, extensions []string) bool {
+	languages := argsparser.GetArgVals(args, "-x")
+	if len(languages) != 0 && languages[len(languages)-1] != "none" {
+		return false
+	}
+	inputs := argsparser.Inputs(args)
+	if len(inputs) == 0 {
+		return false
+	}
+	for _, input := range inputs {
+		hasExtension := false
+		for _, ext := range extensions {
+			if filepath.Ext(input) == ext {
+				hasExtension = true
+				break
+			}
+		}
+		if !hasExtension {
+			return false
+		}
+	}
+	return true
+}
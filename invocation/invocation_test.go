@@ -0,0 +1,112 @@
+package invocation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestClassify(t *testing.T) {
+	var testcases = []struct {
+		name         string
+		inputArgs    []string
+		expectedKind Kind
+	}{
+		{
+			name:         "Object compilation",
+			inputArgs:    []string{"-c", "hello.c", "-o", "hello.o"},
+			expectedKind: Kind_CompileToObject,
+		},
+		{
+			name:         "Assembly compilation",
+			inputArgs:    []string{"-S", "hello.c", "-o", "hello.s"},
+			expectedKind: Kind_CompileToAssembly,
+		},
+		{
+			name:         "-c -E preprocesses",
+			inputArgs:    []string{"-c", "-E", "hello.c"},
+			expectedKind: Kind_Preprocess,
+		},
+		{
+			name:         "Dependency scan",
+			inputArgs:    []string{"-MM", "hello.c"},
+			expectedKind: Kind_DependencyScan,
+		},
+		{
+			name:         "Depfile during object compilation",
+			inputArgs:    []string{"-MD", "-MF", "hello.d", "-c", "hello.c"},
+			expectedKind: Kind_CompileToObject,
+		},
+		{
+			name:         "Syntax check",
+			inputArgs:    []string{"-c", "-fsyntax-only", "hello.c"},
+			expectedKind: Kind_Other,
+		},
+		{
+			name:         "Print commands",
+			inputArgs:    []string{"-###", "-c", "hello.c"},
+			expectedKind: Kind_Other,
+		},
+		{
+			name:         "PCH from -x",
+			inputArgs:    []string{"-x", "c-header", "-c", "prefix.pch", "-o", "prefix.pch.gch"},
+			expectedKind: Kind_PrecompiledHeader,
+		},
+		{
+			name:         "PCH from extension",
+			inputArgs:    []string{"-c", "prefix.h"},
+			expectedKind: Kind_PrecompiledHeader,
+		},
+		{
+			name:         "C++20 module",
+			inputArgs:    []string{"--precompile", "foo.cppm", "-o", "foo.pcm"},
+			expectedKind: Kind_ModuleBuild,
+		},
+		{
+			name:         "Clang module",
+			inputArgs:    []string{"-Xclang", "-emit-module", "-c", "module.modulemap"},
+			expectedKind: Kind_ModuleBuild,
+		},
+		{
+			name:         "Assembler with cpp",
+			inputArgs:    []string{"-x", "assembler-with-cpp", "-c", "start.S"},
+			expectedKind: Kind_Assemble,
+		},
+		{
+			name:         "Assembly from extension",
+			inputArgs:    []string{"-c", "start.s"},
+			expectedKind: Kind_Assemble,
+		},
+		{
+			name:         "Bitcode emission",
+			inputArgs:    []string{"-c", "-emit-llvm", "hello.c"},
+			expectedKind: Kind_Other,
+		},
+		{
+			name:         "Link",
+			inputArgs:    []string{"hello.o", "-o", "hello"},
+			expectedKind: Kind_Link,
+		},
+		{
+			name:         "No inputs",
+			inputArgs:    []string{"--version"},
+			expectedKind: Kind_Other,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expectedKind, Classify(tc.inputArgs))
+		})
+	}
+}
+
+func TestUnmarshalKind(t *testing.T) {
+	var kinds []Kind
+	err := yaml.Unmarshal([]byte("[compile-to-object, compile-to-assembly]"), &kinds)
+	require.NoError(t, err)
+	require.Equal(t, []Kind{Kind_CompileToObject, Kind_CompileToAssembly}, kinds)
+
+	err = yaml.Unmarshal([]byte("[compile-to-obj]"), &kinds)
+	require.Error(t, err)
+}
@@ -0,0 +1,31 @@
+package invocation
+
+import (
+	"strconv"
+
+	"github.com/afjoseph/conjunct/argsparser"
+)
+
+// OptLevels are the optimization levels GetOptLevel() returns
+var OptLevels = []string{"-O0", "-O1", "-O2", "-O3", "-Os", "-Oz", "-Og", "-Ofast"}
+
+// GetOptLevel returns the optimization level of 'args', normalized like
+// clang does:
+//   - The last -O argument wins
+//   - No -O argument is "-O0", and "-O" alone is "-O1"
+//   - Levels above 3 (e.g., "-O4") are "-O3"
+//
+// Unknown levels are returned as they are
+func GetOptLevel(args []string) string {
+	if !argsparser.HasArg(args, "-O") {
+		return "-O0"
+	}
+	level := argsparser.GetArgVal(args, "-O")
+	if len(level) == 0 {
+		return "-O1"
+	}
+	if n, err := strconv.Atoi(level); err == nil && n > 3 {
+		return "-O3"
+	}
+	return "-O" + level
+}
@@ -0,0 +1,56 @@
+package invocation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetOptLevel(t *testing.T) {
+	var testcases = []struct {
+		name           string
+		inputArgs      []string
+		expectedRetval string
+	}{
+		{
+			name:           "No -O",
+			inputArgs:      []string{"-c", "foo.c"},
+			expectedRetval: "-O0",
+		},
+		{
+			name:           "-O alone",
+			inputArgs:      []string{"-O", "-c", "foo.c"},
+			expectedRetval: "-O1",
+		},
+		{
+			name:           "Last one wins",
+			inputArgs:      []string{"-O2", "-c", "foo.c", "-Oz"},
+			expectedRetval: "-Oz",
+		},
+		{
+			name:           "Above -O3",
+			inputArgs:      []string{"-O4", "-c", "foo.c"},
+			expectedRetval: "-O3",
+		},
+		{
+			name:           "Not the value of another option",
+			inputArgs:      []string{"-Xclang", "-O3", "-c", "foo.c"},
+			expectedRetval: "-O0",
+		},
+		{
+			name:           "-ObjC is not an opt level",
+			inputArgs:      []string{"-O2", "-ObjC", "-c", "foo.m"},
+			expectedRetval: "-O2",
+		},
+		{
+			name:           "-ObjC++ alone",
+			inputArgs:      []string{"-ObjC++", "-c", "foo.mm"},
+			expectedRetval: "-O0",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expectedRetval, GetOptLevel(tc.inputArgs))
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package invocation
+
+import (
+	"github.com/afjoseph/conjunct/argsparser"
+	"github.com/afjoseph/conjunct/util"
+)
+
+// GetOutputPath returns the path of the output of compiling 'sourceFilepath'
+// with 'args': the value of -o if there's one, else the path clang derives
+// from 'sourceFilepath'. See GetDefaultOutputPath()
+func GetOutputPath(args []string, sourceFilepath string) string {
+	outputPath := argsparser.GetArgVal(args, "-o")
+	if len(outputPath) != 0 {
+		return outputPath
+	}
+	return GetDefaultOutputPath(args, sourceFilepath)
+}
+
+// GetDefaultOutputPath returns the path clang writes the output of compiling
+// 'sourceFilepath' with 'args' to when there's no -o: the basename of
+// 'sourceFilepath' in the working directory with an extension that depends
+// on the output type: '.s' for assembly (i.e., -S), else '.o'.
+//
+// This is also the output path of every source file when 'args' compiles
+// multiple ones, since clang doesn't accept -o then.
+//
+// XXX Only invocations that can run the pipeline (see
+// Kind.CanRunPipeline()) get here, so -emit-llvm outputs (i.e., Kind_Other)
+// aren't handled: clang names those itself
+func GetDefaultOutputPath(args []string, sourceFilepath string) string {
+	ext := ".o"
+	if argsparser.HasArg(args, "-S") {
+		ext = ".s"
+	}
+	return util.GetBasenameWithoutExtension(sourceFilepath) + ext
+}
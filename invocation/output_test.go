@@ -0,0 +1,50 @@
+package invocation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetOutputPath(t *testing.T) {
+	var testcases = []struct {
+		name                string
+		inputArgs           []string
+		inputSourceFilepath string
+		expectedRetval      string
+	}{
+		{
+			name:                "Explicit output",
+			inputArgs:           []string{"-c", "src/foo.c", "-o", "out/foo.o"},
+			inputSourceFilepath: "src/foo.c",
+			expectedRetval:      "out/foo.o",
+		},
+		{
+			name:                "Object in the working directory",
+			inputArgs:           []string{"-c", "src/foo.c"},
+			inputSourceFilepath: "src/foo.c",
+			expectedRetval:      "foo.o",
+		},
+		{
+			name:                "Assembly",
+			inputArgs:           []string{"-S", "src/foo.cpp"},
+			inputSourceFilepath: "src/foo.cpp",
+			expectedRetval:      "foo.s",
+		},
+		{
+			name:                "Object from IR",
+			inputArgs:           []string{"-c", "foo.ll"},
+			inputSourceFilepath: "foo.ll",
+			expectedRetval:      "foo.o",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(
+				t,
+				tc.expectedRetval,
+				GetOutputPath(tc.inputArgs, tc.inputSourceFilepath),
+			)
+		})
+	}
+}
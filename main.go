@@ -45,8 +45,22 @@ func init() {
 	}
 }
 
+// conjunctBinaryName is the name of the Conjunct binary, as opposed to the
+// clang shims it's installed as (e.g., a 'clang' symlink)
+const conjunctBinaryName = "conjunct"
+
 func main() {
-	args := os.Args[1:]
+	// Check for Conjunct's own commands (i.e., 'conjunct config validate').
+	// As a clang shim, 'config' is just an argument of the build
+	if len(os.Args) > 1 && os.Args[1] == "config" &&
+		filepath.Base(os.Args[0]) == conjunctBinaryName {
+		os.Exit(runConfigCommand(os.Args[2:]))
+	}
+	// Expand response files first: every other argument can be in one
+	args, hasResponseFiles, err := argsparser.ExpandResponseFiles(os.Args[1:])
+	if err != nil {
+		panic(errors.Wrapf(err, "failed to expand response files"))
+	}
 	// Check for version flag
 	if argsparser.HasArg(args, "--version") {
 		fmt.Printf(
@@ -56,17 +70,23 @@ func main() {
 		)
 		os.Exit(0)
 	}
+	// Add the Conjunct flags that come from the environment
+	args, argOrigins := config.ResolveConjunctArgs(args)
 	// Check for verbose flags
 	if argsparser.HasArg(args, "--conjunct-verbose") {
 		logrus.SetLevel(logrus.DebugLevel)
 		logrus.Debugf("Running conjunct in verbose mode")
 	}
+	argOrigins.Log()
 	args = argsparser.RemoveArg(args, "--conjunct-verbose", false)
 	// Extract config
 	args, cfg, err := config.ExtractConfigFromArgs(args)
 	if err != nil {
 		panic(errors.Wrapf(err, "failed to extract config from args"))
 	}
+	if cfg != nil {
+		cfg.UseResponseFiles = hasResponseFiles
+	}
 
 	// Find which clang binary to run: clang or clang++
 	_, sourceFileType := sourcefile.GetSourceFileName(args)
@@ -78,6 +98,9 @@ func main() {
 	// If there's no config provided, find a clang binary to run, run it and
 	// exit
 	if cfg == nil {
+		// Conjunct flags can come from the environment without a config:
+		// clang doesn't know them
+		args = argsparser.RemoveRegexArg(args, "^--conjunct-")
 		clangPath := ""
 		// If we have a default clang dir, use it
 		if DefaultClangDir != "" {
@@ -127,7 +150,7 @@ func getClangBinaryName(
 ) string {
 	// If the binary name is not conjunct and it's a clang binary, use it
 	// directly
-	if baseProgramName != "conjunct" &&
+	if baseProgramName != conjunctBinaryName &&
 		strings.HasPrefix(baseProgramName, "clang") {
 		return baseProgramName
 	}
@@ -137,11 +160,10 @@ func getClangBinaryName(
 	// mainly to know which clang binary to run since there is a difference
 	// between clang++ and clang:
 	// https://github.com/llvm/llvm-project/issues/54701#issuecomment-1086055306
-	if sourceFileType == sourcefile.Type_C {
-		return "clang"
-	} else if sourceFileType == sourcefile.Type_CPP ||
-		sourceFileType == sourcefile.Type_OBJC {
+	if sourceFileType.IsCPlusPlus() {
 		return "clang++"
+	} else if sourceFileType != sourcefile.Type_Unknown {
+		return "clang"
 	}
 	// If we have no idea what the source file type is, just default to clang++
 	return "clang++"
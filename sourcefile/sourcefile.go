@@ -8,66 +8,237 @@ import (
 
 type Type int
 
+// Type_Unknown is the type of a file that isn't a known source file
+const Type_Unknown Type = -1
+
 const (
-	Type_C       Type = 1
-	Type_CPP     Type = 2
-	Type_OBJC    Type = 3
-	Type_Unknown Type = -1
+	Type_C Type = iota + 1
+	Type_CPP
+	Type_OBJC
+	Type_OBJCPP
+	// Preprocessed sources (i.e., the output of 'clang -E')
+	Type_CPreprocessed
+	Type_CPPPreprocessed
+	Type_OBJCPreprocessed
+	Type_OBJCPPPreprocessed
+	// Assembly sources, without and with the preprocessor
+	Type_Assembly
+	Type_AssemblyWithCpp
+	// LLVM IR sources, textual and bitcode
+	Type_LLVMIR
+	Type_Bitcode
+	Type_CUDA
 )
 
-var (
-	cppFileExtensions  = []string{".cpp", ".cc", ".cxx", ".c++"}
-	objcFileExtensions = []string{".m"}
-	cFileExtensions    = []string{".c"}
-)
+// typeInfo is what's known about a source file type: its file extensions
+// and the names clang gives to its language (i.e., values of -x). The first
+// language is the one clang uses
+type typeInfo struct {
+	t          Type
+	extensions []string
+	languages  []string
+}
 
-func FetchType(path string) Type {
-	ext := filepath.Ext(path)
-	for _, e := range cppFileExtensions {
-		if e == ext {
-			return Type_CPP
+// XXX Extensions are case-sensitive, like in clang: '.C' is C++, '.c' is C
+var typeInfos = []typeInfo{
+	{Type_C, []string{".c"}, []string{"c"}},
+	{
+		Type_CPP,
+		[]string{".cpp", ".cc", ".cxx", ".c++", ".C", ".cp", ".CPP", ".CXX"},
+		[]string{"c++"},
+	},
+	{Type_OBJC, []string{".m"}, []string{"objective-c"}},
+	{Type_OBJCPP, []string{".mm", ".M"}, []string{"objective-c++"}},
+	{Type_CPreprocessed, []string{".i"}, []string{"cpp-output", "c-cpp-output"}},
+	{Type_CPPPreprocessed, []string{".ii"}, []string{"c++-cpp-output"}},
+	{
+		Type_OBJCPreprocessed,
+		[]string{".mi"},
+		[]string{"objective-c-cpp-output", "objc-cpp-output"},
+	},
+	{
+		Type_OBJCPPPreprocessed,
+		[]string{".mii"},
+		[]string{"objective-c++-cpp-output", "objc++-cpp-output"},
+	},
+	{Type_Assembly, []string{".s"}, []string{"assembler"}},
+	{Type_AssemblyWithCpp, []string{".S", ".sx"}, []string{"assembler-with-cpp"}},
+	{Type_LLVMIR, []string{".ll"}, []string{"ir"}},
+	{Type_Bitcode, []string{".bc"}, nil},
+	{Type_CUDA, []string{".cu"}, []string{"cuda"}},
+}
+
+// ClangLanguage returns the name clang gives to the language of 't' (i.e.,
+// the value of -x), or an empty string if it's unknown
+func (t Type) ClangLanguage() string {
+	if t == Type_Bitcode {
+		// Clang reads bitcode and textual IR with the same language
+		return "ir"
+	}
+	for _, info := range typeInfos {
+		if info.t == t && len(info.languages) != 0 {
+			return info.languages[0]
 		}
 	}
-	for _, e := range objcFileExtensions {
-		if e == ext {
-			return Type_OBJC
+	return ""
+}
+
+// IsCPlusPlus returns true if 't' is a C++ family language, i.e., one that
+// needs clang++ to link. Objective-C, preprocessed or not, is not: it
+// builds with clang
+func (t Type) IsCPlusPlus() bool {
+	switch t {
+	case Type_CPP, Type_OBJCPP, Type_CPPPreprocessed,
+		Type_OBJCPPPreprocessed, Type_CUDA:
+		return true
+	}
+	return false
+}
+
+// IsIR returns true if 't' is LLVM IR, i.e., it doesn't need to be emitted
+// to bitcode before running the stages
+func (t Type) IsIR() bool {
+	return t == Type_LLVMIR || t == Type_Bitcode
+}
+
+// CanRunPipeline returns true if the pipeline can run on sources of type
+// 't'. Assembly doesn't go through LLVM IR, and CUDA sources compile to
+// several objects (host and device), so they can't
+func (t Type) CanRunPipeline() bool {
+	switch t {
+	case Type_Unknown, Type_Assembly, Type_AssemblyWithCpp, Type_CUDA:
+		return false
+	}
+	return true
+}
+
+// FetchType returns the type of the source file in 'path' from its
+// extension
+func FetchType(path string) Type {
+	ext := filepath.Ext(path)
+	for _, info := range typeInfos {
+		for _, e := range info.extensions {
+			if e == ext {
+				return info.t
+			}
 		}
 	}
-	for _, e := range cFileExtensions {
-		if e == ext {
-			return Type_C
+	return Type_Unknown
+}
+
+// FetchTypeFromLanguage returns the type of the clang language 'language'
+// (i.e., the value of -x)
+func FetchTypeFromLanguage(language string) Type {
+	for _, info := range typeInfos {
+		for _, l := range info.languages {
+			if l == language {
+				return info.t
+			}
 		}
 	}
 	return Type_Unknown
 }
 
-// GetSourceFileName fetches the source file name from 'args'
+// SourceFile is a source file of a compiler invocation
+type SourceFile struct {
+	// Path is the path of the source file as it was passed to the compiler
+	Path string
+	// Type is the type of the source file: either the language of the last
+	// -x argument before it, or the type of its extension
+	Type Type
+	// Index is the index of the source file in the args it was found in
+	Index int
+}
+
+// GetSourceFileName fetches the basename of the source file from 'args'. See
+// GetSourceFilePath() for details
+func GetSourceFileName(args []string) (string, Type) {
+	sourceFilePath, t := GetSourceFilePath(args)
+	if len(sourceFilePath) == 0 {
+		return "", t
+	}
+	return filepath.Base(sourceFilePath), t
+}
+
+// GetSourceFilePath fetches the first source file path from 'args', as it
+// was passed to the compiler, and its type. See GetSourceFiles() for details
+func GetSourceFilePath(args []string) (string, Type) {
+	sourceFiles := GetSourceFiles(args)
+	if len(sourceFiles) == 0 {
+		return "", Type_Unknown
+	}
+	return sourceFiles[0].Path, sourceFiles[0].Type
+}
+
+// GetSourceFilePaths fetches every source file path from 'args', in order,
+// as they were passed to the compiler. See GetSourceFiles() for details
+func GetSourceFilePaths(args []string) []string {
+	sourceFiles := GetSourceFiles(args)
+	if len(sourceFiles) == 0 {
+		return nil
+	}
+	sourceFilePaths := []string{}
+	for _, sourceFile := range sourceFiles {
+		sourceFilePaths = append(sourceFilePaths, sourceFile.Path)
+	}
+	return sourceFilePaths
+}
+
+// GetSourceFiles fetches every source file from 'args', in order.
 // There are two methods:
-//   - First one is to get the value of -c argument since most compilers
-//     put the source file name there. It's not a guarantee, just a convention,
-//     so this can fail
-//   - Second one is to get the argument containing a file with a .c or .cpp
+//   - First one is to get every input (see argsparser.Inputs()) with a known
+//     type. Like in clang, the type of an input is the language of the last
+//     -x argument before it ("-x none" resets it), or else the type of its
+//     extension. Values of options (e.g., "-o foo.c") are not inputs
+//   - If that fails, get the input right after the -c argument since most
+//     compilers put the source file name there. It's not a guarantee, just a
+//     convention, so this can fail
 //
 // XXX <02-03-2024, afjoseph> Both methods are not accurate so I'm waiting for
 // the command that breaks this function breaks to make it better
-func GetSourceFileName(args []string) (string, Type) {
-	// First method: get the value of -c argument
-	sourceFileName := argsparser.GetArgVal(args, "-c")
-	if len(sourceFileName) != 0 {
-		// get the basename
-		sourceFileName = filepath.Base(sourceFileName)
-		return sourceFileName, FetchType(sourceFileName)
-	}
-
-	// Second method: run through all arguments and check if it's a C/CXX file
-	for _, arg := range args {
-		arg = filepath.Base(arg)
-		t := FetchType(arg)
+func GetSourceFiles(args []string) []SourceFile {
+	sourceFiles := []SourceFile{}
+	language := ""
+	parsedArgs := argsparser.Parse(args)
+	for _, parsedArg := range parsedArgs {
+		if parsedArg.Option != nil && parsedArg.Option.Name == "-x" {
+			language = ""
+			if len(parsedArg.Values) != 0 && parsedArg.Values[0] != "none" {
+				language = parsedArg.Values[0]
+			}
+			continue
+		}
+		if !parsedArg.IsInput() {
+			continue
+		}
+		t := FetchType(parsedArg.Spelling)
+		if len(language) != 0 {
+			t = FetchTypeFromLanguage(language)
+		}
 		if t == Type_Unknown {
 			continue
 		}
-		return arg, t
+		sourceFiles = append(sourceFiles, SourceFile{
+			Path:  parsedArg.Spelling,
+			Type:  t,
+			Index: parsedArg.Index,
+		})
+	}
+	if len(sourceFiles) != 0 {
+		return sourceFiles
 	}
 
-	return "", Type_Unknown
+	for i, parsedArg := range parsedArgs {
+		if parsedArg.Option == nil || parsedArg.Option.Name != "-c" {
+			continue
+		}
+		if i+1 < len(parsedArgs) && parsedArgs[i+1].IsInput() {
+			return []SourceFile{{
+				Path:  parsedArgs[i+1].Spelling,
+				Type:  Type_Unknown,
+				Index: parsedArgs[i+1].Index,
+			}}
+		}
+	}
+	return nil
 }
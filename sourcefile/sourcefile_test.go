@@ -0,0 +1,141 @@
+package sourcefile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSourceFilePaths(t *testing.T) {
+	var testcases = []struct {
+		name           string
+		inputArgs      []string
+		expectedRetval []string
+	}{
+		{
+			name:           "Single source file",
+			inputArgs:      []string{"-c", "hello.c", "-o", "hello.o"},
+			expectedRetval: []string{"hello.c"},
+		},
+		{
+			name:           "Multiple source files",
+			inputArgs:      []string{"-O2", "-c", "a.c", "dir/b.cpp", "-Wall"},
+			expectedRetval: []string{"a.c", "dir/b.cpp"},
+		},
+		{
+			name:           "Source file not after -c",
+			inputArgs:      []string{"-c", "-o", "hello.o", "hello.c"},
+			expectedRetval: []string{"hello.c"},
+		},
+		{
+			name:           "Values of other args are not source files",
+			inputArgs:      []string{"-c", "a.c", "-include", "prefix.c", "-MF", "x.c"},
+			expectedRetval: []string{"a.c"},
+		},
+		{
+			name:           "Unknown extension after -c",
+			inputArgs:      []string{"-c", "hello.unknown", "-o", "hello.o"},
+			expectedRetval: []string{"hello.unknown"},
+		},
+		{
+			name:           "No source file",
+			inputArgs:      []string{"a.o", "b.o", "-o", "hello"},
+			expectedRetval: nil,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expectedRetval, GetSourceFilePaths(tc.inputArgs))
+		})
+	}
+}
+
+func TestFetchType(t *testing.T) {
+	var testcases = []struct {
+		name         string
+		inputPath    string
+		expectedType Type
+		// expectedCPlusPlus is true if the type needs clang++
+		expectedCPlusPlus bool
+	}{
+		{name: "C", inputPath: "a.c", expectedType: Type_C},
+		{name: "C++ with capital C", inputPath: "a.C", expectedType: Type_CPP, expectedCPlusPlus: true},
+		{name: "C++ with .cp", inputPath: "a.cp", expectedType: Type_CPP, expectedCPlusPlus: true},
+		{name: "Objective-C", inputPath: "a.m", expectedType: Type_OBJC},
+		{name: "Objective-C++", inputPath: "a.mm", expectedType: Type_OBJCPP, expectedCPlusPlus: true},
+		{name: "Objective-C++ with capital M", inputPath: "a.M", expectedType: Type_OBJCPP, expectedCPlusPlus: true},
+		{name: "Preprocessed C", inputPath: "a.i", expectedType: Type_CPreprocessed},
+		{name: "Preprocessed C++", inputPath: "a.ii", expectedType: Type_CPPPreprocessed, expectedCPlusPlus: true},
+		{name: "Preprocessed Objective-C", inputPath: "a.mi", expectedType: Type_OBJCPreprocessed},
+		{name: "Assembly", inputPath: "a.s", expectedType: Type_Assembly},
+		{name: "Assembly with cpp", inputPath: "a.S", expectedType: Type_AssemblyWithCpp},
+		{name: "LLVM IR", inputPath: "a.ll", expectedType: Type_LLVMIR},
+		{name: "Bitcode", inputPath: "a.bc", expectedType: Type_Bitcode},
+		{name: "CUDA", inputPath: "a.cu", expectedType: Type_CUDA, expectedCPlusPlus: true},
+		{name: "Object", inputPath: "a.o", expectedType: Type_Unknown},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expectedType, FetchType(tc.inputPath))
+			require.Equal(t, tc.expectedCPlusPlus, tc.expectedType.IsCPlusPlus())
+		})
+	}
+}
+
+func TestGetSourceFiles(t *testing.T) {
+	var testcases = []struct {
+		name           string
+		inputArgs      []string
+		expectedRetval []SourceFile
+	}{
+		{
+			name:      "Type from extension",
+			inputArgs: []string{"-c", "a.mm", "-o", "a.o"},
+			expectedRetval: []SourceFile{
+				{Path: "a.mm", Type: Type_OBJCPP, Index: 1},
+			},
+		},
+		{
+			name:      "-x overrides the extension",
+			inputArgs: []string{"-x", "objective-c++", "-c", "a.h"},
+			expectedRetval: []SourceFile{
+				{Path: "a.h", Type: Type_OBJCPP, Index: 3},
+			},
+		},
+		{
+			name:      "Joined -x",
+			inputArgs: []string{"-xc++", "-c", "a.c"},
+			expectedRetval: []SourceFile{
+				{Path: "a.c", Type: Type_CPP, Index: 2},
+			},
+		},
+		{
+			name:      "-x applies to the inputs after it and -x none resets it",
+			inputArgs: []string{"-c", "a.c", "-x", "c++", "b.c", "-x", "none", "c.c"},
+			expectedRetval: []SourceFile{
+				{Path: "a.c", Type: Type_C, Index: 1},
+				{Path: "b.c", Type: Type_CPP, Index: 4},
+				{Path: "c.c", Type: Type_C, Index: 7},
+			},
+		},
+		{
+			name:      "Option values aren't source files",
+			inputArgs: []string{"-Xclang", "-main-file-name", "-Xclang", "a.c", "-c", "a.c"},
+			expectedRetval: []SourceFile{
+				{Path: "a.c", Type: Type_C, Index: 5},
+			},
+		},
+		{
+			name:      "-x ir",
+			inputArgs: []string{"-x", "ir", "-c", "a.bc"},
+			expectedRetval: []SourceFile{
+				{Path: "a.bc", Type: Type_LLVMIR, Index: 3},
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expectedRetval, GetSourceFiles(tc.inputArgs))
+		})
+	}
+}
@@ -0,0 +1,22 @@
+; ModuleID = 'hello.c'
+source_filename = "hello.c"
+
+define i32 @main() {
+entry:
+  %x = alloca i32, align 4
+  store i32 0, i32* %x, align 4
+  %0 = load i32, i32* %x, align 4
+  switch i32 %0, label %default [
+    i32 1, label %one
+    i32 2, label %two
+  ]
+
+one:
+  ret i32 1
+
+two:
+  ret i32 2
+
+default:
+  ret i32 0
+}
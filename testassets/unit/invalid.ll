@@ -0,0 +1,7 @@
+; Parses fine but fails the verifier: %x doesn't dominate its use
+define i32 @main() {
+entry:
+  %y = add i32 %x, 1
+  %x = add i32 1, 1
+  ret i32 %y
+}
@@ -0,0 +1,149 @@
+package universal
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"os"
+
+	"github.com/go-playground/errors/v5"
+)
+
+// This file merges thin Mach-O files into a universal (i.e., "fat") Mach-O
+// file, like 'lipo -create' does. It's written in Go since lipo is not
+// available outside of macOS.
+// Ref: https://github.com/apple-oss-distributions/cctools/blob/main/include/mach-o/fat.h
+
+const (
+	fatHeaderSize = 8
+	fatArchSize   = 20
+	// alignment of slices that aren't object files, as a power of 2
+	alignARM     = 14 // 16KB pages
+	alignDefault = 12 // 4KB pages
+	// maxSectAlign is the maximum alignment of object file slices, as a
+	// power of 2 (i.e., MAXSECTALIGN in lipo)
+	maxSectAlign = 15
+	// cpuSubtypeMask masks the capability bits out of a CPU subtype (i.e.,
+	// CPU_SUBTYPE_MASK in mach/machine.h)
+	cpuSubtypeMask = 0xff000000
+)
+
+type slice struct {
+	path   string
+	data   []byte
+	cpu    macho.Cpu
+	subCpu uint32
+	align  uint32
+	offset uint32
+}
+
+// Merge merges the thin Mach-O files in 'slicePaths' into a universal Mach-O
+// file at 'outPath'. Every slice must have a different CPU type and subtype,
+// capability bits aside.
+func Merge(outPath string, slicePaths []string) error {
+	if len(slicePaths) == 0 {
+		return errors.New("no slices to merge")
+	}
+	slices := []*slice{}
+	for _, slicePath := range slicePaths {
+		s, err := readSlice(slicePath)
+		if err != nil {
+			return err
+		}
+		for _, other := range slices {
+			if other.cpu == s.cpu &&
+				other.subCpu&^cpuSubtypeMask == s.subCpu&^cpuSubtypeMask {
+				return errors.Newf(
+					"%s and %s have the same architecture (%s)",
+					other.path,
+					s.path,
+					s.cpu,
+				)
+			}
+		}
+		slices = append(slices, s)
+	}
+
+	// Lay out the slices after the headers, each one aligned on its
+	// alignment
+	offset := uint64(fatHeaderSize + fatArchSize*len(slices))
+	for _, s := range slices {
+		alignment := uint64(1) << s.align
+		offset = (offset + alignment - 1) &^ (alignment - 1)
+		if offset+uint64(len(s.data)) > 0xFFFFFFFF {
+			return errors.New("universal file too large: 64-bit fat files are not supported")
+		}
+		s.offset = uint32(offset)
+		offset += uint64(len(s.data))
+	}
+
+	var buf bytes.Buffer
+	write := func(v any) {
+		// Writing to a bytes.Buffer never fails
+		_ = binary.Write(&buf, binary.BigEndian, v)
+	}
+	write(uint32(macho.MagicFat))
+	write(uint32(len(slices)))
+	for _, s := range slices {
+		write(uint32(s.cpu))
+		write(s.subCpu)
+		write(s.offset)
+		write(uint32(len(s.data)))
+		write(s.align)
+	}
+	for _, s := range slices {
+		buf.Write(make([]byte, int(s.offset)-buf.Len()))
+		buf.Write(s.data)
+	}
+	err := os.WriteFile(outPath, buf.Bytes(), 0644)
+	if err != nil {
+		return errors.Wrapf(err, "while writing %s", outPath)
+	}
+	return nil
+}
+
+func readSlice(path string) (*slice, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while reading %s", path)
+	}
+	f, err := macho.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s is not a thin Mach-O file", path)
+	}
+	defer f.Close()
+	s := &slice{
+		path:   path,
+		data:   data,
+		cpu:    f.Cpu,
+		subCpu: f.SubCpu,
+		align:  getAlign(f),
+	}
+	return s, nil
+}
+
+// getAlign returns the alignment of the slice 'f' in a universal file, as a
+// power of 2, like lipo does: the maximum alignment of its sections for an
+// object file, or else the page size of its CPU
+func getAlign(f *macho.File) uint32 {
+	if f.Type != macho.TypeObj {
+		if f.Cpu == macho.CpuArm || f.Cpu == macho.CpuArm64 {
+			return alignARM
+		}
+		return alignDefault
+	}
+	// At least the alignment of the load commands
+	align := uint32(2)
+	if f.Magic == macho.Magic64 {
+		align = 3
+	}
+	for _, sect := range f.Sections {
+		if sect.Align > align {
+			align = sect.Align
+		}
+	}
+	if align > maxSectAlign {
+		align = maxSectAlign
+	}
+	return align
+}
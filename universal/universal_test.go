@@ -0,0 +1,181 @@
+package universal
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeThinObject writes a minimal 64-bit Mach-O file of type 'fileType'
+// for 'cpu' and returns its path. If 'sectAlign' isn't 0, the file has a
+// segment with a single section of that alignment
+func writeThinObject(
+	t *testing.T,
+	dir string,
+	name string,
+	cpu macho.Cpu,
+	subCpu uint32,
+	fileType macho.Type,
+	sectAlign uint32,
+) string {
+	var cmds bytes.Buffer
+	header := macho.FileHeader{
+		Magic:  macho.Magic64,
+		Cpu:    cpu,
+		SubCpu: subCpu,
+		Type:   fileType,
+	}
+	if sectAlign != 0 {
+		segment := macho.Segment64{
+			Cmd:   macho.LoadCmdSegment64,
+			Len:   72 + 80,
+			Nsect: 1,
+		}
+		section := macho.Section64{Align: sectAlign}
+		copy(section.Name[:], "__text")
+		copy(section.Seg[:], "__TEXT")
+		require.NoError(t, binary.Write(&cmds, binary.LittleEndian, segment))
+		require.NoError(t, binary.Write(&cmds, binary.LittleEndian, section))
+		header.Ncmd = 1
+		header.Cmdsz = uint32(cmds.Len())
+	}
+	var buf bytes.Buffer
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, header))
+	// Reserved field of mach_header_64
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, uint32(0)))
+	buf.Write(cmds.Bytes())
+	buf.WriteString(name)
+	path := filepath.Join(dir, name+".o")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+	return path
+}
+
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+	arm64Path := writeThinObject(t, dir, "arm64", macho.CpuArm64, 0, macho.TypeObj, 4)
+	x86Path := writeThinObject(t, dir, "x86_64", macho.CpuAmd64, 3, macho.TypeObj, 0)
+	outPath := filepath.Join(dir, "fat.o")
+	require.NoError(t, Merge(outPath, []string{arm64Path, x86Path}))
+
+	fat, err := macho.OpenFat(outPath)
+	require.NoError(t, err)
+	defer fat.Close()
+	require.Len(t, fat.Arches, 2)
+	// Object files are aligned on their maximum section alignment, or on
+	// the alignment of their load commands
+	require.Equal(t, macho.CpuArm64, fat.Arches[0].Cpu)
+	require.Equal(t, uint32(4), fat.Arches[0].Align)
+	require.Zero(t, fat.Arches[0].Offset%(1<<4))
+	require.Equal(t, macho.CpuAmd64, fat.Arches[1].Cpu)
+	require.Equal(t, uint32(3), fat.Arches[1].SubCpu)
+	require.Equal(t, uint32(3), fat.Arches[1].Align)
+	require.Zero(t, fat.Arches[1].Offset%(1<<3))
+
+	// Every slice is copied as is
+	b, err := os.ReadFile(outPath)
+	require.NoError(t, err)
+	for i, slicePath := range []string{arm64Path, x86Path} {
+		sliceData, err := os.ReadFile(slicePath)
+		require.NoError(t, err)
+		arch := fat.Arches[i]
+		require.Equal(t, sliceData, b[arch.Offset:arch.Offset+arch.Size])
+	}
+}
+
+func TestMergeAlign(t *testing.T) {
+	var testcases = []struct {
+		name          string
+		inputCpu      macho.Cpu
+		inputType     macho.Type
+		inputAlign    uint32
+		expectedAlign uint32
+	}{
+		{
+			name:          "arm64 dylib is page-aligned",
+			inputCpu:      macho.CpuArm64,
+			inputType:     macho.TypeDylib,
+			inputAlign:    4,
+			expectedAlign: alignARM,
+		},
+		{
+			name:          "x86_64 executable is page-aligned",
+			inputCpu:      macho.CpuAmd64,
+			inputType:     macho.TypeExec,
+			expectedAlign: alignDefault,
+		},
+		{
+			name:          "Object file alignment is capped",
+			inputCpu:      macho.CpuArm64,
+			inputType:     macho.TypeObj,
+			inputAlign:    20,
+			expectedAlign: maxSectAlign,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			slicePath := writeThinObject(
+				t,
+				dir,
+				"slice",
+				tc.inputCpu,
+				0,
+				tc.inputType,
+				tc.inputAlign,
+			)
+			outPath := filepath.Join(dir, "fat")
+			require.NoError(t, Merge(outPath, []string{slicePath}))
+			fat, err := macho.OpenFat(outPath)
+			require.NoError(t, err)
+			defer fat.Close()
+			require.Equal(t, tc.expectedAlign, fat.Arches[0].Align)
+			require.Zero(t, fat.Arches[0].Offset%(1<<tc.expectedAlign))
+		})
+	}
+}
+
+func TestMergeErrors(t *testing.T) {
+	dir := t.TempDir()
+	arm64Path := writeThinObject(t, dir, "arm64", macho.CpuArm64, 0, macho.TypeObj, 0)
+	// Same subtype, with a capability bit (i.e., CPU_SUBTYPE_PTRAUTH_ABI)
+	otherArm64Path := writeThinObject(
+		t,
+		dir,
+		"other",
+		macho.CpuArm64,
+		0x80000000,
+		macho.TypeObj,
+		0,
+	)
+	notMachOPath := filepath.Join(dir, "not-macho.o")
+	require.NoError(t, os.WriteFile(notMachOPath, []byte("hello"), 0644))
+
+	var testcases = []struct {
+		name            string
+		inputSlicePaths []string
+	}{
+		{
+			name:            "No slices",
+			inputSlicePaths: nil,
+		},
+		{
+			name:            "Duplicate architecture",
+			inputSlicePaths: []string{arm64Path, otherArm64Path},
+		},
+		{
+			name:            "Not a Mach-O file",
+			inputSlicePaths: []string{arm64Path, notMachOPath},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Merge(filepath.Join(dir, "fat.o"), tc.inputSlicePaths)
+			require.Error(t, err)
+		})
+	}
+}
@@ -1,8 +1,7 @@
 package util
 
 import (
-	"fmt"
-	"os/exec"
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -12,45 +11,44 @@ import (
 // Expand expands the path to an absolute path.
 // It does two more things than `filepath.Abs`:
 // - Expands `~`, `.` and `..` symbols
-// - Expands environment variables
+// - Expands environment variables (e.g., `$HOME` or `${HOME}`)
+//
+// If 'expandSymlinks' is true, symlinks are resolved as well, like
+// `realpath` does: every component but the last one must exist.
+//
+// XXX It doesn't spawn a shell: it runs for every path of the config file on
+// every compiler invocation
 func ExpandPath(path string, expandSymlinks bool) (string, error) {
-	// Check if realpath is there
-	_, err := exec.LookPath("realpath")
-	if err != nil {
-		return "", errors.Wrapf(err, "failed to find realpath")
+	if len(path) == 0 {
+		return "", errors.New("failed to expand an empty path")
+	}
+	path = os.ExpandEnv(path)
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to expand ~ in %s", path)
+		}
+		path = filepath.Join(homeDir, path[1:])
 	}
-	path, err = ResolveShellVariables(path)
+	absPath, err := filepath.Abs(path)
 	if err != nil {
-		return "", errors.Wrapf(
-			err,
-			"failed to resolve shell variables in %s",
-			path,
-		)
+		return "", errors.Wrapf(err, "failed to expand path %s", path)
 	}
-	cmdArgs := []string{}
 	if !expandSymlinks {
-		cmdArgs = append(cmdArgs, "--no-symlinks")
+		return absPath, nil
 	}
-	cmdArgs = append(cmdArgs, path)
-	cmd := exec.Command("realpath", cmdArgs...)
-	b, err := cmd.Output()
-	if err != nil {
+	realPath, err := filepath.EvalSymlinks(absPath)
+	if err == nil {
+		return realPath, nil
+	}
+	if !os.IsNotExist(err) {
 		return "", errors.Wrapf(err, "failed to expand path %s", path)
 	}
-	return strings.TrimSpace(string(b)), nil
-}
-
-func ResolveShellVariables(path string) (string, error) {
-	cmd := exec.Command("bash", "-c", fmt.Sprintf("echo %s", path))
-	b, err := cmd.Output()
+	realDir, err := filepath.EvalSymlinks(filepath.Dir(absPath))
 	if err != nil {
-		return "", errors.Wrapf(
-			err,
-			"failed to resolve shell variables in %s",
-			path,
-		)
+		return "", errors.Wrapf(err, "failed to expand path %s", path)
 	}
-	return strings.TrimSpace(string(b)), nil
+	return filepath.Join(realDir, filepath.Base(absPath)), nil
 }
 
 // GetBasenameWithoutExtension returns the basename of a path without the
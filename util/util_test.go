@@ -0,0 +1,75 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandPath(t *testing.T) {
+	dir := t.TempDir()
+	realDir := filepath.Join(dir, "real")
+	require.NoError(t, os.Mkdir(realDir, 0755))
+	linkDir := filepath.Join(dir, "link")
+	require.NoError(t, os.Symlink(realDir, linkDir))
+	evaledRealDir, err := filepath.EvalSymlinks(realDir)
+	require.NoError(t, err)
+	homeDir, err := os.UserHomeDir()
+	require.NoError(t, err)
+	t.Setenv("CONJUNCT_TEST_DIR", linkDir)
+
+	var testcases = []struct {
+		name                string
+		inputPath           string
+		inputExpandSymlinks bool
+		expectedRetval      string
+		expectedError       bool
+	}{
+		{
+			name:           "Environment variables",
+			inputPath:      "${CONJUNCT_TEST_DIR}/../link/./foo",
+			expectedRetval: filepath.Join(linkDir, "foo"),
+		},
+		{
+			name:           "Home dir",
+			inputPath:      "~/foo",
+			expectedRetval: filepath.Join(homeDir, "foo"),
+		},
+		{
+			name:                "Symlinks",
+			inputPath:           "$CONJUNCT_TEST_DIR",
+			inputExpandSymlinks: true,
+			expectedRetval:      evaledRealDir,
+		},
+		{
+			name:                "Symlinks with a missing last component",
+			inputPath:           "$CONJUNCT_TEST_DIR/missing",
+			inputExpandSymlinks: true,
+			expectedRetval:      filepath.Join(evaledRealDir, "missing"),
+		},
+		{
+			name:                "Symlinks with a missing dir",
+			inputPath:           "$CONJUNCT_TEST_DIR/missing/foo",
+			inputExpandSymlinks: true,
+			expectedError:       true,
+		},
+		{
+			name:          "Empty path",
+			inputPath:     "",
+			expectedError: true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			actualRetval, err := ExpandPath(tc.inputPath, tc.inputExpandSymlinks)
+			if tc.expectedError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedRetval, actualRetval)
+		})
+	}
+}